@@ -0,0 +1,60 @@
+// Command elevatorserver is a stand-in for the TTK4145 elevator server. It
+// simulates one elevator car and serves the 4-byte command protocol used by
+// elevio.TCPDriver, so the elevator program can be run end to end locally.
+//
+// Inputs are read as commands from stdin, or from a script file with -script,
+// see package simcmd.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"../../elevio"
+	"../../elevio/simcmd"
+)
+
+func main() {
+	var port int
+	var numFloors int
+	var travelTime time.Duration
+	var script string
+	var view bool
+	flag.IntVar(&port, "port", 15657, "Port to listen for the elevator program on")
+	flag.IntVar(&numFloors, "numFloors", 4, "Number of floors")
+	flag.DurationVar(&travelTime, "travelTime", 2*time.Second, "Time to travel between two floors")
+	flag.StringVar(&script, "script", "", "Read commands from this file instead of stdin")
+	flag.BoolVar(&view, "view", true, "Draw a textual view of the shaft")
+	flag.Parse()
+
+	if numFloors < 2 || numFloors > 255 {
+		fmt.Println("numFloors must be between 2 and 255")
+		os.Exit(1)
+	}
+
+	car := elevio.NewSimDriver(numFloors, travelTime)
+
+	var input io.Reader = os.Stdin
+	if script != "" {
+		file, err := os.Open(script)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		defer file.Close()
+		input = file
+	}
+
+	go simcmd.ReadCommands(input, car)
+	if view {
+		go drawView(car)
+	}
+
+	if err := serve(port, car); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
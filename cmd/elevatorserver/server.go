@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+
+	"../../elevio"
+)
+
+// serve accepts one elevator program at a time on port and answers its
+// commands until it disconnects.
+func serve(port int, car *elevio.SimDriver) error {
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		handle(conn, car)
+	}
+}
+
+func handle(conn net.Conn, car *elevio.SimDriver) {
+	defer conn.Close()
+
+	var buf [4]byte
+	for {
+		if _, err := io.ReadFull(conn, buf[:]); err != nil {
+			return
+		}
+		reply := execute(buf, car)
+		if reply != nil {
+			if _, err := conn.Write(reply); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// execute applies a single 4-byte command to car. Read commands (6-9) return
+// the 4-byte reply, write commands (1-5) return nil.
+func execute(cmd [4]byte, car *elevio.SimDriver) []byte {
+	switch cmd[0] {
+	case 1:
+		car.SetMotorDirection(elevio.MotorDirection(int8(cmd[1])))
+	case 2:
+		car.SetButtonLamp(elevio.ButtonType(cmd[1]), int(cmd[2]), cmd[3] != 0)
+	case 3:
+		car.SetFloorIndicator(int(cmd[1]))
+	case 4:
+		car.SetDoorOpenLamp(cmd[1] != 0)
+	case 5:
+		car.SetStopLamp(cmd[1] != 0)
+	case 6:
+		return []byte{6, toByte(car.GetButton(elevio.ButtonType(cmd[1]), int(cmd[2]))), 0, 0}
+	case 7:
+		floor := car.GetFloor()
+		if floor == -1 {
+			return []byte{7, 0, 0, 0}
+		}
+		return []byte{7, 1, byte(floor), 0}
+	case 8:
+		return []byte{8, toByte(car.GetStop()), 0, 0}
+	case 9:
+		return []byte{9, toByte(car.GetObstruction()), 0, 0}
+	}
+	return nil
+}
+
+func toByte(a bool) byte {
+	if a {
+		return 1
+	}
+	return 0
+}
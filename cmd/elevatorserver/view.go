@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"../../elevio"
+)
+
+const viewRefreshRate = 100 * time.Millisecond
+
+// drawView redraws the shaft whenever the state of car changes.
+func drawView(car *elevio.SimDriver) {
+	prev := ""
+	for {
+		time.Sleep(viewRefreshRate)
+		view := renderView(car)
+		if view != prev {
+			fmt.Print("\x1b[2J\x1b[H", view)
+			prev = view
+		}
+	}
+}
+
+// renderView draws one line per floor, top floor first, with the hall and cab
+// button lamps and the car position, followed by a status line.
+func renderView(car *elevio.SimDriver) string {
+	var sb strings.Builder
+	position := car.Position()
+
+	sb.WriteString("Floor  Up Dn Cab  Shaft\n")
+	for floor := car.NumFloors() - 1; floor >= 0; floor-- {
+		shaft := "|   |"
+		if position > float64(floor)-0.5 && position <= float64(floor)+0.5 {
+			shaft = "| # |"
+			if car.GetFloor() == -1 {
+				shaft = "| : |"
+			}
+		}
+		fmt.Fprintf(&sb, "%5d  %s  %s  %s   %s\n",
+			floor,
+			lamp(car.ButtonLamp(elevio.BT_HallUp, floor) && floor < car.NumFloors()-1),
+			lamp(car.ButtonLamp(elevio.BT_HallDown, floor) && floor > 0),
+			lamp(car.ButtonLamp(elevio.BT_Cab, floor)),
+			shaft)
+	}
+
+	motor := map[elevio.MotorDirection]string{
+		elevio.MD_Up:   "up",
+		elevio.MD_Down: "down",
+		elevio.MD_Stop: "stopped",
+	}[car.MotorDirection()]
+	if car.MotorLoss() {
+		motor = "no power"
+	}
+	fmt.Fprintf(&sb, "\nMotor: %-8s  Floor indicator: %d  Door: %s  Stop: %s  Obstruction: %s\n",
+		motor,
+		car.FloorIndicator(),
+		onOff(car.DoorOpenLamp()),
+		onOff(car.StopLamp()),
+		onOff(car.GetObstruction()))
+	return sb.String()
+}
+
+func lamp(on bool) string {
+	if on {
+		return "*"
+	}
+	return "-"
+}
+
+func onOff(on bool) string {
+	if on {
+		return "on"
+	}
+	return "off"
+}
@@ -1,11 +1,114 @@
 package config
 
-const (
-	NumFloors                = 4
-	MaxNumElevators          = 4
-	NumButtonTypes           = 3
-	WatchDogTimerDuration    = 10
-	DoorTimerDuration        = 3
-	MotorLossTimerDuration   = 4
-	SendSyncMsgTimerDuration = 100
+import (
+	"flag"
+	"time"
 )
+
+// NumButtonTypes is the number of buttons per floor: hall up, hall down and cab.
+const NumButtonTypes = 3
+
+// Config holds the building geometry, network ports and timer durations,
+// read at startup and passed to every module.
+type Config struct {
+	NumFloors int
+	// MaxNumElevators is the most elevators, this one included, kept in the
+	// peer table. Further peers are rejected.
+	MaxNumElevators int
+	// PeerEvictionDuration is how long a lost peer is kept in the peer table.
+	PeerEvictionDuration time.Duration
+
+	SimPort      int
+	BcastPort    int
+	PeersPort    int
+	ReliablePort int
+
+	// ReliableEvents enables sending hall order changes to the peers as
+	// acknowledged events on ReliablePort, on top of the state broadcasts.
+	ReliableEvents bool
+
+	StateDir string
+
+	DispatchStrategy string
+
+	// ReassignInterval is how often all held hall orders are reassigned. Zero
+	// disables reassignment. It only applies to the simulation strategy.
+	ReassignInterval time.Duration
+	// ReassignHysteresis is how much sooner all hall orders must be served
+	// before they are moved from the elevators holding them.
+	ReassignHysteresis time.Duration
+	// WaitPenaltyDuration is the wait after which a hall order counts double
+	// with the simulation strategy.
+	WaitPenaltyDuration time.Duration
+	// MaxWaitDuration is the wait after which a hall order is given to the
+	// nearest idle elevator.
+	MaxWaitDuration time.Duration
+
+	WatchDogTimerDuration    time.Duration
+	DoorTimerDuration        time.Duration
+	MotorLossTimerDuration   time.Duration
+	SendSyncMsgTimerDuration time.Duration
+	RetransmitTimerDuration  time.Duration
+	TravelTimeDuration       time.Duration
+	ObstructionTimeDuration  time.Duration
+}
+
+// Default returns the configuration of the lab elevators.
+func Default() Config {
+	return Config{
+		NumFloors:            4,
+		MaxNumElevators:      4,
+		PeerEvictionDuration: 5 * time.Minute,
+
+		SimPort:      15657,
+		BcastPort:    20017,
+		PeersPort:    20018,
+		ReliablePort: 20019,
+
+		ReliableEvents: false,
+
+		StateDir: "esm",
+
+		DispatchStrategy: "simulation",
+
+		ReassignInterval:    1 * time.Second,
+		ReassignHysteresis:  2 * time.Second,
+		WaitPenaltyDuration: 20 * time.Second,
+		MaxWaitDuration:     40 * time.Second,
+
+		WatchDogTimerDuration:    10 * time.Second,
+		DoorTimerDuration:        3 * time.Second,
+		MotorLossTimerDuration:   4 * time.Second,
+		SendSyncMsgTimerDuration: 100 * time.Millisecond,
+		RetransmitTimerDuration:  50 * time.Millisecond,
+		TravelTimeDuration:       2 * time.Second,
+		ObstructionTimeDuration:  5 * time.Second,
+	}
+}
+
+// AddFlags registers a command line flag for each field of c, using the
+// current values as defaults. The flag names are also the setting names used
+// in configuration files.
+func (c *Config) AddFlags(fs *flag.FlagSet) {
+	fs.IntVar(&c.NumFloors, "numFloors", c.NumFloors, "Number of floors")
+	fs.IntVar(&c.MaxNumElevators, "maxNumElevators", c.MaxNumElevators, "Maximum number of elevators in the peer table")
+	fs.DurationVar(&c.PeerEvictionDuration, "peerEviction", c.PeerEvictionDuration, "Time a lost peer is kept in the peer table")
+	fs.IntVar(&c.SimPort, "simPort", c.SimPort, "Simulator connection port")
+	fs.IntVar(&c.BcastPort, "bcastPort", c.BcastPort, "Port for synchronization messages")
+	fs.IntVar(&c.PeersPort, "peersPort", c.PeersPort, "Port for peer discovery")
+	fs.IntVar(&c.ReliablePort, "reliablePort", c.ReliablePort, "Port for acknowledged events")
+	fs.BoolVar(&c.ReliableEvents, "reliableEvents", c.ReliableEvents, "Send hall order changes to the peers as acknowledged events")
+	fs.StringVar(&c.StateDir, "stateDir", c.StateDir, "Directory for the order backup files of this node")
+	fs.StringVar(&c.DispatchStrategy, "dispatchStrategy", c.DispatchStrategy, "Hall order dispatch strategy: heuristic, nearest or simulation")
+	fs.DurationVar(&c.ReassignInterval, "reassignInterval", c.ReassignInterval, "Time between reassignments of all hall orders with the simulation strategy, 0 disables it")
+	fs.DurationVar(&c.ReassignHysteresis, "reassignHysteresis", c.ReassignHysteresis, "Time saved before hall orders are moved between elevators")
+	fs.DurationVar(&c.WaitPenaltyDuration, "waitPenalty", c.WaitPenaltyDuration, "Wait after which a hall order counts double with the simulation strategy")
+	fs.DurationVar(&c.MaxWaitDuration, "maxWait", c.MaxWaitDuration, "Wait after which a hall order is given to the nearest idle elevator")
+	fs.DurationVar(&c.WatchDogTimerDuration, "watchDogTimer", c.WatchDogTimerDuration, "Time an order may be late before it is redistributed")
+	fs.DurationVar(&c.DoorTimerDuration, "doorTimer", c.DoorTimerDuration, "Time the door is kept open")
+	fs.DurationVar(&c.MotorLossTimerDuration, "motorLossTimer", c.MotorLossTimerDuration, "Time between floors before motor loss")
+	fs.DurationVar(&c.SendSyncMsgTimerDuration, "syncInterval", c.SendSyncMsgTimerDuration, "Interval between synchronization messages")
+	fs.DurationVar(&c.RetransmitTimerDuration, "retransmitInterval", c.RetransmitTimerDuration, "Time before an unacknowledged event is sent again")
+	fs.DurationVar(&c.TravelTimeDuration, "travelTime", c.TravelTimeDuration, "Time to travel between two floors")
+	fs.DurationVar(&c.ObstructionTimeDuration, "obstructionTimer", c.ObstructionTimeDuration, "Time obstructed before no new orders are taken")
+}
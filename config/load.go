@@ -0,0 +1,135 @@
+package config
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+)
+
+// Load returns the default configuration overridden by the JSON file at path,
+// then by the flags in flags that were set on the command line, and checks
+// that the result is valid. The file is an object of setting names as used by
+// AddFlags, with durations written as strings:
+//
+//	{"numFloors": 4, "doorTimer": "3s", "bcastPort": 20017}
+//
+// An empty path skips the file.
+func Load(path string, flags *flag.FlagSet) (Config, error) {
+	cfg := Default()
+	settings := flag.NewFlagSet("config", flag.ContinueOnError)
+	cfg.AddFlags(settings)
+
+	if path != "" {
+		if err := readFile(path, settings); err != nil {
+			return Config{}, err
+		}
+	}
+
+	var err error
+	flags.Visit(func(f *flag.Flag) {
+		if err == nil && settings.Lookup(f.Name) != nil {
+			err = settings.Set(f.Name, f.Value.String())
+		}
+	})
+	if err != nil {
+		return Config{}, fmt.Errorf("config: %v", err)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+func readFile(path string, settings *flag.FlagSet) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config: %v", err)
+	}
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(data, &values); err != nil {
+		return fmt.Errorf("config: %s: %v", path, err)
+	}
+
+	for name, raw := range values {
+		if settings.Lookup(name) == nil {
+			return fmt.Errorf("config: %s: unknown setting %q", path, name)
+		}
+		// Strings are unquoted, numbers are passed on as written.
+		value := string(raw)
+		var s string
+		if json.Unmarshal(raw, &s) == nil {
+			value = s
+		}
+		if err := settings.Set(name, value); err != nil {
+			return fmt.Errorf("config: %s: %s: %v", path, name, err)
+		}
+	}
+	return nil
+}
+
+// Validate checks that every setting is in range and that the timers are
+// consistent with each other.
+func (c Config) Validate() error {
+	if c.NumFloors < 2 || c.NumFloors > 255 {
+		return fmt.Errorf("config: numFloors must be between 2 and 255, got %d", c.NumFloors)
+	}
+	if c.MaxNumElevators < 1 {
+		return fmt.Errorf("config: maxNumElevators must be at least 1, got %d", c.MaxNumElevators)
+	}
+
+	ports := map[string]int{"simPort": c.SimPort, "bcastPort": c.BcastPort, "peersPort": c.PeersPort, "reliablePort": c.ReliablePort}
+	for name, port := range ports {
+		if port < 1 || port > 65535 {
+			return fmt.Errorf("config: %s must be between 1 and 65535, got %d", name, port)
+		}
+	}
+	if c.BcastPort == c.PeersPort {
+		return fmt.Errorf("config: bcastPort and peersPort must differ, both are %d", c.BcastPort)
+	}
+	if c.ReliablePort == c.BcastPort || c.ReliablePort == c.PeersPort {
+		return fmt.Errorf("config: reliablePort must differ from bcastPort and peersPort, got %d", c.ReliablePort)
+	}
+
+	if c.StateDir == "" {
+		return fmt.Errorf("config: stateDir must not be empty")
+	}
+
+	timers := map[string]int64{
+		"watchDogTimer":      int64(c.WatchDogTimerDuration),
+		"doorTimer":          int64(c.DoorTimerDuration),
+		"motorLossTimer":     int64(c.MotorLossTimerDuration),
+		"syncInterval":       int64(c.SendSyncMsgTimerDuration),
+		"retransmitInterval": int64(c.RetransmitTimerDuration),
+		"travelTime":         int64(c.TravelTimeDuration),
+		"obstructionTimer":   int64(c.ObstructionTimeDuration),
+		"waitPenalty":        int64(c.WaitPenaltyDuration),
+		"maxWait":            int64(c.MaxWaitDuration),
+		"peerEviction":       int64(c.PeerEvictionDuration),
+	}
+	for name, duration := range timers {
+		if duration <= 0 {
+			return fmt.Errorf("config: %s must be positive", name)
+		}
+	}
+	if c.ReassignInterval < 0 {
+		return fmt.Errorf("config: reassignInterval must not be negative")
+	}
+	if c.ReassignHysteresis < 0 {
+		return fmt.Errorf("config: reassignHysteresis must not be negative")
+	}
+	if c.DoorTimerDuration >= c.WatchDogTimerDuration {
+		return fmt.Errorf("config: doorTimer (%v) must be shorter than watchDogTimer (%v)",
+			c.DoorTimerDuration, c.WatchDogTimerDuration)
+	}
+	if c.TravelTimeDuration >= c.MotorLossTimerDuration {
+		return fmt.Errorf("config: travelTime (%v) must be shorter than motorLossTimer (%v)",
+			c.TravelTimeDuration, c.MotorLossTimerDuration)
+	}
+	if c.SendSyncMsgTimerDuration >= c.DoorTimerDuration {
+		return fmt.Errorf("config: syncInterval (%v) must be shorter than doorTimer (%v)",
+			c.SendSyncMsgTimerDuration, c.DoorTimerDuration)
+	}
+	return nil
+}
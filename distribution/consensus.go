@@ -0,0 +1,203 @@
+package distribution
+
+import (
+	"fmt"
+	"time"
+
+	"../config"
+	"../elevio"
+	"../esm"
+)
+
+// AssignmentTable is the owner node ID has decided for each hall order, and
+// when the owner must have served it. The nodes broadcast their tables so they
+// can agree on one owner per hall order.
+type AssignmentTable struct {
+	ID string
+	// Owners holds the ID of the elevator serving each order, indexed like
+	// the local queue. "" means the order is not assigned.
+	Owners [][]string
+	// Deadlines holds when each order is redistributed if it is not served,
+	// in unix milliseconds by the local clock. 0 means the order has not been
+	// decided on. Like esm.ElevData.ConfirmedAt, tables are sent with the
+	// milliseconds left instead, see sent and received.
+	Deadlines [][]int64
+}
+
+func newAssignmentTable(id string, numFloors int) AssignmentTable {
+	table := AssignmentTable{
+		ID:        id,
+		Owners:    make([][]string, config.NumButtonTypes),
+		Deadlines: make([][]int64, config.NumButtonTypes),
+	}
+	for i := range table.Owners {
+		table.Owners[i] = make([]string, numFloors)
+		table.Deadlines[i] = make([]int64, numFloors)
+	}
+	return table
+}
+
+func (table AssignmentTable) copy() AssignmentTable {
+	tableCopy := AssignmentTable{
+		ID:        table.ID,
+		Owners:    make([][]string, len(table.Owners)),
+		Deadlines: make([][]int64, len(table.Deadlines)),
+	}
+	for i := range table.Owners {
+		tableCopy.Owners[i] = append([]string(nil), table.Owners[i]...)
+		tableCopy.Deadlines[i] = append([]int64(nil), table.Deadlines[i]...)
+	}
+	return tableCopy
+}
+
+func (table AssignmentTable) owner(order elevio.ButtonEvent) string {
+	return table.Owners[order.Button][order.Floor]
+}
+
+func (table AssignmentTable) deadline(order elevio.ButtonEvent) time.Time {
+	return time.Unix(0, table.Deadlines[order.Button][order.Floor]*int64(time.Millisecond))
+}
+
+// sent returns a copy of table to broadcast at now, with each deadline as the
+// milliseconds left until it, negative once it has passed.
+func (table AssignmentTable) sent(now time.Time) AssignmentTable {
+	sentTable := table.copy()
+	for i := range sentTable.Deadlines {
+		for j, deadline := range sentTable.Deadlines[i] {
+			if deadline == 0 {
+				continue
+			}
+			left := deadline - unixMillis(now)
+			if left == 0 {
+				left = -1
+			}
+			sentTable.Deadlines[i][j] = left
+		}
+	}
+	return sentTable
+}
+
+// received returns a copy of table, broadcast by a peer and received at now,
+// with the deadlines turned back into unix milliseconds by the local clock.
+func (table AssignmentTable) received(now time.Time) AssignmentTable {
+	receivedTable := table.copy()
+	for i := range receivedTable.Deadlines {
+		for j, left := range receivedTable.Deadlines[i] {
+			if left != 0 {
+				receivedTable.Deadlines[i][j] = unixMillis(now) + left
+			}
+		}
+	}
+	return receivedTable
+}
+
+func unixMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// assign records owner as the owner of order, to have served it by deadline.
+// A zero deadline removes the order from the table.
+func (table AssignmentTable) assign(order elevio.ButtonEvent, owner string, deadline time.Time) {
+	table.Owners[order.Button][order.Floor] = owner
+	table.Deadlines[order.Button][order.Floor] = 0
+	if !deadline.IsZero() {
+		table.Deadlines[order.Button][order.Floor] = unixMillis(deadline)
+	}
+}
+
+// adopt copies the owner and deadline of order from other.
+func (table AssignmentTable) adopt(other AssignmentTable, order elevio.ButtonEvent) {
+	table.Owners[order.Button][order.Floor] = other.Owners[order.Button][order.Floor]
+	table.Deadlines[order.Button][order.Floor] = other.Deadlines[order.Button][order.Floor]
+}
+
+// consensusMetrics counts how often the assignment tables of the nodes
+// disagree.
+type consensusMetrics struct {
+	// comparisons is the number of orders compared with a peer.
+	comparisons int
+	// disagreements is the number of times an order was found assigned to
+	// different owners by this node and a peer.
+	disagreements int
+	// adopted is the number of owners taken from a peer to resolve a
+	// disagreement.
+	adopted int
+}
+
+func (m consensusMetrics) String() string {
+	rate := 0.0
+	if m.comparisons > 0 {
+		rate = 100 * float64(m.disagreements) / float64(m.comparisons)
+	}
+	return fmt.Sprintf("%d disagreements in %d comparisons (%.2f%%), %d owners adopted",
+		m.disagreements, m.comparisons, rate, m.adopted)
+}
+
+// disagreement identifies an order the table of peer disagrees on.
+type disagreement struct {
+	peer  string
+	order elevio.ButtonEvent
+}
+
+// compareTables compares the hall order owners of this node with the table of
+// a peer, and returns the orders to take the owner of from the peer. On a
+// disagreement the node with the highest ID is right, unless its owner has
+// missed the deadline and is about to be redistributed by the peer as well.
+func compareTables(mine AssignmentTable, table AssignmentTable, now time.Time, metrics *consensusMetrics, disagreeing map[disagreement]bool) []elevio.ButtonEvent {
+	if !hasSameShape(mine, table) {
+		return nil
+	}
+	var adopt []elevio.ButtonEvent
+	for buttonNr := range mine.Owners {
+		if buttonNr == elevio.BT_Cab {
+			continue
+		}
+		for floorNr := range mine.Owners[buttonNr] {
+			myOwner, theirOwner := mine.Owners[buttonNr][floorNr], table.Owners[buttonNr][floorNr]
+			if myOwner == "" || theirOwner == "" {
+				continue
+			}
+			order := elevio.MakeButtonEvent(buttonNr, floorNr)
+			key := disagreement{peer: table.ID, order: order}
+			metrics.comparisons++
+			if myOwner == theirOwner {
+				delete(disagreeing, key)
+				continue
+			}
+			if !disagreeing[key] {
+				disagreeing[key] = true
+				metrics.disagreements++
+				fmt.Printf("Assignment disagreement with %s: Floor: %d Button: %d, mine: %s theirs: %s. Assignment consensus: %v\n",
+					table.ID, floorNr, buttonNr, myOwner, theirOwner, metrics)
+			}
+			if table.ID > mine.ID && !now.After(table.deadline(order)) {
+				metrics.adopted++
+				adopt = append(adopt, order)
+			}
+		}
+	}
+	return adopt
+}
+
+// hasSameShape returns whether table covers the same buttons and floors as mine.
+func hasSameShape(mine AssignmentTable, table AssignmentTable) bool {
+	if len(table.Owners) != len(mine.Owners) || len(table.Deadlines) != len(mine.Owners) {
+		return false
+	}
+	for i := range mine.Owners {
+		if len(table.Owners[i]) != len(mine.Owners[i]) || len(table.Deadlines[i]) != len(mine.Owners[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// isOnline returns whether the elevator with id is an online peer.
+func isOnline(elevData []esm.ElevData, id string) bool {
+	for _, elev := range elevData {
+		if elev.ID == id {
+			return elev.Online
+		}
+	}
+	return false
+}
@@ -0,0 +1,50 @@
+package distribution
+
+import (
+	"testing"
+	"time"
+
+	"../elevio"
+)
+
+// A deadline broadcast by a node keeps the time left to it at a peer whose
+// clock is an hour behind, and a passed deadline stays passed.
+func TestDeadlinesSurviveClockSkew(t *testing.T) {
+	pending := elevio.ButtonEvent{Floor: 1, Button: elevio.BT_HallUp}
+	missed := elevio.ButtonEvent{Floor: 2, Button: elevio.BT_HallDown}
+	undecided := elevio.ButtonEvent{Floor: 3, Button: elevio.BT_HallDown}
+
+	senderNow := time.Unix(10000, 0)
+	mine := newAssignmentTable("b", 4)
+	mine.assign(pending, "a", senderNow.Add(5*time.Second))
+	mine.assign(missed, "a", senderNow.Add(-2*time.Second))
+	mine.assign(undecided, "a", time.Time{})
+
+	const latency = 20 * time.Millisecond
+	receiverNow := senderNow.Add(-time.Hour + latency)
+	table := mine.sent(senderNow).received(receiverNow)
+
+	if left := table.deadline(pending).Sub(receiverNow); left != 5*time.Second {
+		t.Errorf("pending deadline %v away at the peer, want %v", left, 5*time.Second)
+	}
+	if left := table.deadline(missed).Sub(receiverNow); left != -2*time.Second {
+		t.Errorf("missed deadline %v away at the peer, want %v", left, -2*time.Second)
+	}
+	if deadline := table.Deadlines[undecided.Button][undecided.Floor]; deadline != 0 {
+		t.Errorf("undecided order got deadline %d at the peer", deadline)
+	}
+	if owner := table.owner(pending); owner != "a" {
+		t.Errorf("owner %q at the peer, want a", owner)
+	}
+
+	// The peer takes the owner of the pending order from the higher ID, but
+	// not the owner that missed its deadline.
+	peer := newAssignmentTable("a", 4)
+	peer.assign(pending, "c", receiverNow.Add(time.Second))
+	peer.assign(missed, "c", receiverNow.Add(time.Second))
+	var metrics consensusMetrics
+	adopted := compareTables(peer, table, receiverNow, &metrics, make(map[disagreement]bool))
+	if len(adopted) != 1 || adopted[0] != pending {
+		t.Errorf("adopted %v, want only %v", adopted, pending)
+	}
+}
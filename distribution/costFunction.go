@@ -1,6 +1,9 @@
 package distribution
 
 import (
+	"math"
+	"time"
+
 	"../elevio"
 	"../esm"
 )
@@ -65,3 +68,94 @@ func GetCost(elev esm.ElevData, order elevio.ButtonEvent) int {
 	}
 	return cost
 }
+
+// never is the cost of an order that will not be served.
+const never = time.Duration(math.MaxInt64)
+
+// TimeToServe estimates how long elev takes to serve order, by simulating the
+// state machine of elev executing its local queue with order added. A car
+// between floors is assumed halfway, an open door halfway through its timer.
+func TimeToServe(elev esm.ElevData, order elevio.ButtonEvent, travelTime time.Duration, doorTime time.Duration) time.Duration {
+	if !isAvailable(elev) {
+		return never
+	}
+	return serveTimes(elev, []elevio.ButtonEvent{order}, travelTime, doorTime)[order.Button][order.Floor]
+}
+
+// serveTimes simulates elev executing its local queue with orders added, and
+// returns when each order of the queue is served, indexed like the queue.
+// Orders not in the queue are 0, orders that are not served are never.
+func serveTimes(elev esm.ElevData, orders []elevio.ButtonEvent, travelTime time.Duration, doorTime time.Duration) [][]time.Duration {
+	// An obstruction is handled by distribution. Ignore it here so the door
+	// is not held open for ever.
+	elev.Obstructed = false
+
+	times := make([][]time.Duration, len(elev.LocalQueue))
+	for buttonNr := range elev.LocalQueue {
+		times[buttonNr] = make([]time.Duration, len(elev.LocalQueue[buttonNr]))
+		for floorNr, ordered := range elev.LocalQueue[buttonNr] {
+			if ordered == 1 {
+				times[buttonNr][floorNr] = never
+			}
+		}
+	}
+	for _, order := range orders {
+		times[order.Button][order.Floor] = never
+	}
+
+	duration := time.Duration(0)
+	// served records the orders cleared from the queue by now, and returns
+	// whether orders are left.
+	served := func() bool {
+		left := false
+		for buttonNr := range times {
+			for floorNr := range times[buttonNr] {
+				if times[buttonNr][floorNr] != never {
+					continue
+				}
+				if elev.LocalQueue[buttonNr][floorNr] == 0 {
+					times[buttonNr][floorNr] = duration
+				} else {
+					left = true
+				}
+			}
+		}
+		return left
+	}
+
+	travelTimeLeft := travelTime
+	doorTimeLeft := doorTime
+	switch elev.State {
+	case esm.Moving:
+		travelTimeLeft = travelTime / 2
+	case esm.DoorOpen:
+		doorTimeLeft = doorTime / 2
+	}
+
+	for _, order := range orders {
+		elev, _ = esm.Step(elev, esm.Event{Type: esm.NewOrder, Order: order})
+	}
+
+	numFloors := len(elev.LocalQueue[elevio.BT_Cab])
+	maxSteps := 2 * numFloors * (len(elev.LocalQueue) + 1)
+	for step := 0; step < maxSteps && served(); step++ {
+		switch elev.State {
+		case esm.Moving:
+			nextFloor := elev.Floor + int(elev.HeadingDir)
+			if nextFloor < 0 || nextFloor >= numFloors {
+				return times
+			}
+			duration += travelTimeLeft
+			travelTimeLeft = travelTime
+			elev, _ = esm.Step(elev, esm.Event{Type: esm.ArrivedAtFloor, Floor: nextFloor})
+		case esm.DoorOpen:
+			duration += doorTimeLeft
+			doorTimeLeft = doorTime
+			elev, _ = esm.Step(elev, esm.Event{Type: esm.DoorTimeout})
+		default:
+			return times
+		}
+	}
+	served()
+	return times
+}
@@ -0,0 +1,46 @@
+package distribution
+
+import (
+	"testing"
+	"time"
+
+	"../elevio"
+	"../esm"
+)
+
+func TestTimeToServe(t *testing.T) {
+	const travelTime = 2 * time.Second
+	const doorTime = 3 * time.Second
+	cab := func(floor int) elevio.ButtonEvent { return elevio.ButtonEvent{Floor: floor, Button: elevio.BT_Cab} }
+	hallUp := func(floor int) elevio.ButtonEvent { return elevio.ButtonEvent{Floor: floor, Button: elevio.BT_HallUp} }
+	hallDown := func(floor int) elevio.ButtonEvent { return elevio.ButtonEvent{Floor: floor, Button: elevio.BT_HallDown} }
+	for _, c := range []struct {
+		name  string
+		elev  esm.ElevData
+		order elevio.ButtonEvent
+		want  time.Duration
+	}{
+		{"idle at the floor", testElev("a", esm.Idle, 2, esm.HeadingUp), hallUp(2), 0},
+		{"idle below", testElev("a", esm.Idle, 0, esm.HeadingUp), cab(3), 3 * travelTime},
+		{"idle above", testElev("a", esm.Idle, 3, esm.HeadingDown), hallUp(1), 2 * travelTime},
+		// Halfway to floor 1, then one floor on.
+		{"moving towards", testElev("a", esm.Moving, 0, esm.HeadingUp, cab(3)), hallUp(2), travelTime/2 + travelTime},
+		{"moving with a stop between", testElev("a", esm.Moving, 0, esm.HeadingUp, cab(1)), cab(3),
+			travelTime/2 + doorTime + 2*travelTime},
+		{"moving with stops between", testElev("a", esm.Moving, 0, esm.HeadingUp, cab(1), hallUp(2)), cab(3),
+			travelTime/2 + 2*doorTime + 2*travelTime},
+		// Up to the cab order first, then back down.
+		{"moving away", testElev("a", esm.Moving, 1, esm.HeadingUp, cab(3)), hallDown(1),
+			travelTime/2 + travelTime + doorTime + 2*travelTime},
+		// Halfway through the door timer.
+		{"door open", testElev("a", esm.DoorOpen, 1, esm.HeadingUp), cab(3), doorTime/2 + 2*travelTime},
+		{"door open with a stop between", testElev("a", esm.DoorOpen, 1, esm.HeadingUp, cab(2)), cab(3),
+			doorTime/2 + travelTime + doorTime + travelTime},
+		{"emergency", testElev("a", esm.Emergency, 1, esm.HeadingUp), cab(3), never},
+		{"undefined", testElev("a", esm.Undefined, 1, esm.HeadingUp), cab(3), never},
+	} {
+		if got := TimeToServe(c.elev, c.order, travelTime, doorTime); got != c.want {
+			t.Errorf("%s: %v, want %v", c.name, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package distribution
+
+import (
+	"fmt"
+	"time"
+
+	"../config"
+	"../elevio"
+	"../esm"
+)
+
+// orderDeadline returns when owner must have served order, which is the time
+// it is expected to take plus cfg.WatchDogTimerDuration to spare. An order
+// without an owner is retried after cfg.WatchDogTimerDuration.
+func orderDeadline(elevData []esm.ElevData, owner string, order elevio.ButtonEvent, cfg config.Config, now time.Time) time.Time {
+	deadline := now.Add(cfg.WatchDogTimerDuration)
+	for _, elev := range elevData {
+		if elev.ID != owner || owner == "" {
+			continue
+		}
+		if expected := TimeToServe(elev, order, cfg.TravelTimeDuration, cfg.DoorTimerDuration); expected != never {
+			deadline = deadline.Add(expected)
+		}
+		break
+	}
+	return deadline
+}
+
+// missedDeadlines returns the distributed hall orders whose deadline in table
+// has passed. Orders not decided on yet are left to confirmation.
+func missedDeadlines(table AssignmentTable, distributedOrders [][]int, now time.Time) []elevio.ButtonEvent {
+	var orders []elevio.ButtonEvent
+	for buttonNr := range distributedOrders {
+		if buttonNr == elevio.BT_Cab {
+			continue
+		}
+		for floorNr := range distributedOrders[buttonNr] {
+			order := elevio.MakeButtonEvent(buttonNr, floorNr)
+			if distributedOrders[buttonNr][floorNr] == 1 && table.Deadlines[buttonNr][floorNr] != 0 &&
+				now.After(table.deadline(order)) {
+				orders = append(orders, order)
+			}
+		}
+	}
+	return orders
+}
+
+// missReason describes why owner has not served order in time.
+func missReason(elevData []esm.ElevData, owner string, order elevio.ButtonEvent, obstructedSince map[string]time.Time) string {
+	if owner == "" {
+		return "no elevator could take it"
+	}
+	for _, elev := range elevData {
+		if elev.ID != owner {
+			continue
+		}
+		switch {
+		case !elev.Online && elev.ID != elevData[0].ID:
+			return fmt.Sprintf("owner %s is offline", owner)
+		case !isAvailable(elev):
+			return fmt.Sprintf("owner %s is %v", owner, elev.State)
+		case elev.Obstructed:
+			return fmt.Sprintf("owner %s has been obstructed for %v", owner, time.Since(obstructedSince[owner]).Round(time.Second))
+		case elev.LocalQueue[order.Button][order.Floor] == 0:
+			return fmt.Sprintf("owner %s never took it", owner)
+		}
+		return fmt.Sprintf("owner %s did not serve it in time", owner)
+	}
+	return fmt.Sprintf("owner %s is unknown", owner)
+}
+
+// redispatchElevator returns the elevator strategy assigns order to when owner
+// has missed its deadline. owner is only chosen again if it is the only
+// elevator able to take the order.
+func redispatchElevator(strategy Strategy, elevData []esm.ElevData, order elevio.ButtonEvent, owner string, waited map[elevio.ButtonEvent]time.Duration, obstructedSince map[string]time.Time, obstructionTimeout time.Duration) string {
+	if !isAlone(elevData) {
+		var others []esm.ElevData
+		for _, elev := range candidateElevators(elevData, obstructedSince, obstructionTimeout) {
+			if elev.ID != owner {
+				others = append(others, elev)
+			}
+		}
+		if len(others) > 0 {
+			return strategy.Assign(others, order, waited)
+		}
+	}
+	return bestElevator(strategy, elevData, order, waited, obstructedSince, obstructionTimeout)
+}
@@ -1,8 +1,8 @@
 package distribution
 
 import (
-	"math"
-	"strings"
+	"fmt"
+	"time"
 
 	"../config"
 
@@ -14,51 +14,71 @@ import (
 type Channels struct {
 	ButtonPressed           chan elevio.ButtonEvent
 	NewOrder                chan elevio.ButtonEvent
+	RevokeOrder             chan elevio.ButtonEvent
 	SyncedOrderStatus       chan [][]int
 	SyncedElevData          chan []esm.ElevData
-	WatchDogTimeOut         chan bool
 	TurnOnLight             chan elevio.ButtonEvent
 	TurnOffLight            chan elevio.ButtonEvent
 	ClearedOrderStatusOrder chan elevio.ButtonEvent
 	HallOrder               chan elevio.ButtonEvent
+	OutgoingAssignment      chan AssignmentTable
+	IncomingAssignment      chan AssignmentTable
 }
 
-// shouldTakeOrder checks if the current elevator should take the order by comparing costs
-func shouldTakeOrder(elevData []esm.ElevData, order elevio.ButtonEvent) bool {
-	if isAlone(elevData) && elevData[0].State != esm.Undefined {
-		return true
+// bestElevator returns the ID of the elevator strategy assigns order to among
+// the available elevators, or "" if no elevator can take it.
+func bestElevator(strategy Strategy, elevData []esm.ElevData, order elevio.ButtonEvent, waited map[elevio.ButtonEvent]time.Duration, obstructedSince map[string]time.Time, obstructionTimeout time.Duration) string {
+	if isAlone(elevData) {
+		if isAvailable(elevData[0]) {
+			return elevData[0].ID
+		}
+		return ""
 	}
-	bestElevID := ""
-	bestElevCost := math.MaxInt64
-	myID := elevData[0].ID
-
-	for _, elev := range elevData {
-		if elev.Online && elev.State != esm.Undefined {
-			cost := GetCost(elev, order)
-			println("Cost: ", cost)
-			println("bestElevCost:", bestElevCost)
-			println("myID: ", myID)
-			println("bestElevID: ", bestElevID)
-			if cost < bestElevCost || (cost == bestElevCost && strings.Compare(elev.ID, bestElevID) == 1) {
 
-				bestElevID = elev.ID
-				bestElevCost = cost
-			}
-		}
+	candidates := candidateElevators(elevData, obstructedSince, obstructionTimeout)
+	if len(candidates) == 0 {
+		return ""
 	}
-	return bestElevID == myID
+	bestElevID := strategy.Assign(candidates, order, waited)
+	println("bestElevID: ", bestElevID)
+	return bestElevID
 }
 
-//Distribute func distributes
-func Distribute(channels Channels, myID string) {
+//Distribute func distributes hall orders among the elevators using strategy
+func Distribute(channels Channels, driver elevio.Driver, cfg config.Config, strategy Strategy, myID string) {
 
-	elevData := make([]esm.ElevData, config.MaxNumElevators)
+	elevData := make([]esm.ElevData, cfg.MaxNumElevators)
 
 	confirmedOrder := make(chan elevio.ButtonEvent)
 
 	distributedOrders := make([][]int, config.NumButtonTypes)
 	for i := 0; i < config.NumButtonTypes; i++ {
-		distributedOrders[i] = make([]int, config.NumFloors)
+		distributedOrders[i] = make([]int, cfg.NumFloors)
+	}
+
+	// obstructedSince holds when each obstructed elevator was first seen obstructed.
+	obstructedSince := make(map[string]time.Time)
+
+	// wasAvailable holds whether each elevator was available at the previous update.
+	wasAvailable := make(map[string]bool)
+
+	// mine holds the owner and deadline this node has decided for each hall
+	// order. It is broadcast and compared with the tables of the peers.
+	mine := newAssignmentTable(myID, cfg.NumFloors)
+	var metrics consensusMetrics
+	disagreeing := make(map[disagreement]bool)
+	sendAssignmentTimer := time.NewTimer(cfg.SendSyncMsgTimerDuration)
+
+	// escalated holds the hall orders given to the nearest idle elevator after
+	// waiting longer than cfg.MaxWaitDuration. They are not reassigned.
+	escalated := make(assignment)
+
+	reassignTimer := time.NewTimer(cfg.ReassignInterval)
+	if !reassigns(strategy, cfg) {
+		reassignTimer.Stop()
+		if cfg.ReassignInterval > 0 {
+			fmt.Println("distribution: Reassignment disabled, it only runs with the simulation strategy")
+		}
 	}
 
 	for {
@@ -68,7 +88,7 @@ func Distribute(channels Channels, myID string) {
 			println("Received buttonpress")
 			if buttonPressed.Button == elevio.BT_Cab {
 				println("Buttonpress is cab order. Adding to local queue")
-				elevio.SetButtonLamp(buttonPressed.Button, buttonPressed.Floor, true)
+				driver.SetButtonLamp(buttonPressed.Button, buttonPressed.Floor, true)
 				go func() { channels.NewOrder <- buttonPressed }()
 			} else {
 				println("Buttonpress is hall order. Sending to sync")
@@ -79,25 +99,30 @@ func Distribute(channels Channels, myID string) {
 		// Check for completed orders: Send slukk lys.
 		case syncedElevData := <-channels.SyncedElevData:
 			esm.DeepCopy(&elevData, &syncedElevData)
+			updateObstructedSince(obstructedSince, elevData)
 
-			println("Received elevData from Synchronization: ")
-			for elevNr := 0; elevNr < config.MaxNumElevators; elevNr++ {
-				//fmt.Println(elevData[elevNr])
+			// When an elevator stops or resumes taking orders, hall orders it
+			// released are reassigned at once to the best available elevator.
+			if hasAvailabilityChange(wasAvailable, elevData) {
+				waited := waitTimes(elevData[0], time.Now(), cfg.SendSyncMsgTimerDuration)
+				for _, order := range unservedOrders(elevData, distributedOrders) {
+					order := order
+					bestElevID := bestElevator(strategy, elevData, order, waited, obstructedSince, cfg.ObstructionTimeDuration)
+					println("Reassigning released order: Floor:", order.Floor, " Button:", order.Button, " to:", bestElevID)
+					mine.assign(order, bestElevID, orderDeadline(elevData, bestElevID, order, cfg, time.Now()))
+					if bestElevID == myID {
+						go func() { channels.NewOrder <- order }()
+					}
+				}
 			}
-			// Checks whether an order is syncronized over all online elevators.
-			// If syncronized and not already distributed then distribute it.
+
+			println("Received elevData from Synchronization: ")
+			//fmt.Println(elevData)
+			// Checks whether an order is confirmed by all online elevators.
+			// If confirmed and not already distributed then distribute it.
 			for buttonNr := 0; buttonNr < config.NumButtonTypes; buttonNr++ {
-				for floorNr := 0; floorNr < config.NumFloors; floorNr++ {
-					syncOrder := true
-					if isAlone(elevData) {
-						syncOrder = elevData[0].OrderStatus[buttonNr][floorNr] == 1
-					} else {
-						for elevNr := 0; elevNr < config.MaxNumElevators; elevNr++ {
-							if elevData[elevNr].Online && elevData[elevNr].OrderStatus[buttonNr][floorNr] != 1 {
-								syncOrder = false
-							}
-						}
-					}
+				for floorNr := 0; floorNr < cfg.NumFloors; floorNr++ {
+					syncOrder := elevData[0].OrderStatus[buttonNr][floorNr].Phase() == esm.OrderConfirmed
 
 					if syncOrder {
 						println("Synchronized order at floor: ", floorNr, " button: ", buttonNr)
@@ -117,24 +142,128 @@ func Distribute(channels Channels, myID string) {
 		case order := <-channels.ClearedOrderStatusOrder:
 			go func() { channels.TurnOffLight <- order }()
 			distributedOrders[int(order.Button)][order.Floor] = 0
+			mine.assign(order, "", time.Time{})
+			delete(escalated, order)
+			for key := range disagreeing {
+				if key.order == order {
+					delete(disagreeing, key)
+				}
+			}
 
 		case order := <-confirmedOrder:
 			println("Confirmed order: Floor:", order.Floor, " Button:", order.Button)
-			if shouldTakeOrder(elevData, order) {
+			bestElevID := bestElevator(strategy, elevData, order,
+				waitTimes(elevData[0], time.Now(), cfg.SendSyncMsgTimerDuration), obstructedSince, cfg.ObstructionTimeDuration)
+			if order.Button != elevio.BT_Cab {
+				mine.assign(order, bestElevID, orderDeadline(elevData, bestElevID, order, cfg, time.Now()))
+			}
+			if bestElevID == myID {
 				println("Distributed order to thisElev: ", myID)
 				go func() { channels.NewOrder <- order }()
 			}
 
-		// On WatchDogTimeOut: Redistribute all distributedOrders.
-		case <-channels.WatchDogTimeOut:
-			println("Dist: WatchDogTimer timeout")
-			for buttonNr := 0; buttonNr < config.NumButtonTypes; buttonNr++ {
-				for floorNr := 0; floorNr < config.NumFloors; floorNr++ {
-					if distributedOrders[buttonNr][floorNr] == 1 {
-						order := elevio.MakeButtonEvent(buttonNr, floorNr)
-						go func() { confirmedOrder <- order }()
+		// Periodically move held hall orders to the elevators serving them
+		// soonest. The new owner takes the order here, the old owner drops it
+		// when sending its assignment table.
+		case <-reassignTimer.C:
+			reassignTimer.Reset(cfg.ReassignInterval)
+			simulation, simulated := strategy.(SimulationStrategy)
+			if !simulated || isAlone(elevData) {
+				break
+			}
+			candidates := candidateElevators(elevData, obstructedSince, cfg.ObstructionTimeDuration)
+			reassigned := reassignHallOrders(simulation, candidates, heldHallOrders(elevData, distributedOrders),
+				escalated, waitTimes(elevData[0], time.Now(), cfg.SendSyncMsgTimerDuration), cfg.ReassignHysteresis)
+			for order, owner := range reassigned {
+				order := order
+				if owner != mine.owner(order) {
+					mine.assign(order, owner, orderDeadline(elevData, owner, order, cfg, time.Now()))
+				}
+				if owner == myID && elevData[0].LocalQueue[order.Button][order.Floor] == 0 {
+					println("Reassigned order to thisElev: Floor:", order.Floor, " Button:", order.Button)
+					go func() { channels.NewOrder <- order }()
+				}
+			}
+
+		// Broadcast the assignment table, redistribute hall orders whose owner
+		// missed the deadline, give hall orders waiting too long to the
+		// nearest idle elevator, and drop hall orders owned by another
+		// elevator once it is seen holding them.
+		case <-sendAssignmentTimer.C:
+			sendAssignmentTimer.Reset(cfg.SendSyncMsgTimerDuration)
+			now := time.Now()
+			table := mine.sent(now)
+			go func() { channels.OutgoingAssignment <- table }()
+			waited := waitTimes(elevData[0], now, cfg.SendSyncMsgTimerDuration)
+			for _, order := range missedDeadlines(mine, distributedOrders, now) {
+				order := order
+				owner := mine.owner(order)
+				newOwner := redispatchElevator(strategy, elevData, order, owner, waited, obstructedSince, cfg.ObstructionTimeDuration)
+				fmt.Printf("Redistributing order Floor: %d Button: %d to %q: %s, %v past its deadline\n",
+					order.Floor, order.Button, newOwner, missReason(elevData, owner, order, obstructedSince),
+					now.Sub(mine.deadline(order)).Round(time.Millisecond))
+				delete(escalated, order)
+				mine.assign(order, newOwner, orderDeadline(elevData, newOwner, order, cfg, now))
+				if newOwner == myID && elevData[0].LocalQueue[order.Button][order.Floor] == 0 {
+					go func() { channels.NewOrder <- order }()
+				}
+			}
+			if !isAlone(elevData) {
+				candidates := candidateElevators(elevData, obstructedSince, cfg.ObstructionTimeDuration)
+				for order, owner := range escalated {
+					// Escalate again if the elevator can no longer take it.
+					if !isCandidate(candidates, owner) {
+						delete(escalated, order)
 					}
 				}
+				for order, wait := range waited {
+					order := order
+					if wait <= cfg.MaxWaitDuration {
+						continue
+					}
+					if _, found := escalated[order]; found {
+						continue
+					}
+					owner := nearestIdleElevator(candidates, order)
+					if owner == "" {
+						continue
+					}
+					fmt.Printf("Escalating order Floor: %d Button: %d to %s: waited %v, longer than maxWait %v\n",
+						order.Floor, order.Button, owner, wait.Round(time.Second), cfg.MaxWaitDuration)
+					escalated[order] = owner
+					mine.assign(order, owner, orderDeadline(elevData, owner, order, cfg, now))
+					if owner == myID && elevData[0].LocalQueue[order.Button][order.Floor] == 0 {
+						go func() { channels.NewOrder <- order }()
+					}
+				}
+			}
+			for _, order := range heldHallOrders(elevData, distributedOrders) {
+				order := order
+				owner := mine.owner(order)
+				if owner != "" && owner != myID && elevData[0].LocalQueue[order.Button][order.Floor] == 1 &&
+					isOnline(elevData, owner) && isHolding(elevData, owner, order) {
+					println("Handing over order: Floor:", order.Floor, " Button:", order.Button, " to:", owner)
+					go func() { channels.RevokeOrder <- order }()
+				}
+			}
+
+		// Compare the assignment table of a peer with ours, and converge on
+		// the owner of the highest ID on disagreements.
+		case table := <-channels.IncomingAssignment:
+			if table.ID == myID || !isOnline(elevData, table.ID) {
+				break
+			}
+			now := time.Now()
+			table = table.received(now)
+			for _, order := range compareTables(mine, table, now, &metrics, disagreeing) {
+				order := order
+				owner := table.owner(order)
+				println("Adopting owner from", table.ID, ": Floor:", order.Floor, " Button:", order.Button, " to:", owner)
+				mine.adopt(table, order)
+				if owner == myID && elevData[0].LocalQueue[order.Button][order.Floor] == 0 &&
+					elevData[0].OrderStatus[order.Button][order.Floor].Phase() == esm.OrderConfirmed {
+					go func() { channels.NewOrder <- order }()
+				}
 			}
 		}
 	}
@@ -149,3 +278,100 @@ func isAlone(elevData []esm.ElevData) bool {
 	}
 	return true
 }
+
+// candidateElevators returns the online elevators that can be given hall orders.
+func candidateElevators(elevData []esm.ElevData, obstructedSince map[string]time.Time, obstructionTimeout time.Duration) []esm.ElevData {
+	var candidates []esm.ElevData
+	for _, elev := range elevData {
+		if elev.Online && isAvailable(elev) && !isObstructed(elev, obstructedSince, obstructionTimeout) {
+			candidates = append(candidates, elev)
+		}
+	}
+	return candidates
+}
+
+// isCandidate returns whether the elevator with id is among elevs.
+func isCandidate(elevs []esm.ElevData, id string) bool {
+	for _, elev := range elevs {
+		if elev.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// isHolding returns whether the elevator with id has order in its local queue.
+func isHolding(elevs []esm.ElevData, id string, order elevio.ButtonEvent) bool {
+	for _, elev := range elevs {
+		if elev.ID == id {
+			return elev.LocalQueue[order.Button][order.Floor] == 1
+		}
+	}
+	return false
+}
+
+func updateObstructedSince(obstructedSince map[string]time.Time, elevData []esm.ElevData) {
+	for _, elev := range elevData {
+		if !elev.Obstructed {
+			delete(obstructedSince, elev.ID)
+		} else if _, found := obstructedSince[elev.ID]; !found {
+			obstructedSince[elev.ID] = time.Now()
+		}
+	}
+}
+
+// isObstructed returns whether elev has been obstructed for longer than
+// timeout, and should not be given new hall orders.
+func isObstructed(elev esm.ElevData, obstructedSince map[string]time.Time, timeout time.Duration) bool {
+	since, found := obstructedSince[elev.ID]
+	return found && time.Since(since) > timeout
+}
+
+// isAvailable returns whether elev is able to take new orders.
+func isAvailable(elev esm.ElevData) bool {
+	return elev.State != esm.Undefined && elev.State != esm.Emergency
+}
+
+// hasAvailabilityChange updates wasAvailable and returns whether any online
+// elevator became available or unavailable since the last update.
+func hasAvailabilityChange(wasAvailable map[string]bool, elevData []esm.ElevData) bool {
+	changed := false
+	for _, elev := range elevData {
+		if elev.ID == "" {
+			continue
+		}
+		available := (elev.Online || elev.ID == elevData[0].ID) && isAvailable(elev)
+		if prev, found := wasAvailable[elev.ID]; found && prev != available {
+			changed = true
+		}
+		wasAvailable[elev.ID] = available
+	}
+	return changed
+}
+
+// unservedOrders returns the distributed hall orders that are not in the
+// local queue of any available online elevator.
+func unservedOrders(elevData []esm.ElevData, distributedOrders [][]int) []elevio.ButtonEvent {
+	var orders []elevio.ButtonEvent
+	for buttonNr := 0; buttonNr < config.NumButtonTypes; buttonNr++ {
+		if buttonNr == elevio.BT_Cab {
+			continue
+		}
+		for floorNr := range distributedOrders[buttonNr] {
+			if distributedOrders[buttonNr][floorNr] != 1 {
+				continue
+			}
+			served := false
+			for _, elev := range elevData {
+				if (elev.Online || elev.ID == elevData[0].ID) && isAvailable(elev) &&
+					elev.LocalQueue[buttonNr][floorNr] == 1 {
+					served = true
+				}
+			}
+			if !served {
+				orders = append(orders, elevio.MakeButtonEvent(buttonNr, floorNr))
+			}
+		}
+	}
+	return orders
+}
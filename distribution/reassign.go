@@ -0,0 +1,267 @@
+package distribution
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"../config"
+	"../elevio"
+	"../esm"
+)
+
+// assignment maps hall orders to the ID of the elevator serving them.
+type assignment map[elevio.ButtonEvent]string
+
+// maxExhaustiveAssignments is the largest number of assignments tried by
+// globalAssignment. Above it orders are assigned one at a time.
+const maxExhaustiveAssignments = 4096
+
+// reassigns returns whether the held hall orders are reassigned every
+// cfg.ReassignInterval. Reassignment minimises the simulated time to serve all
+// orders, so with any other strategy it would override the strategy's choices.
+func reassigns(strategy Strategy, cfg config.Config) bool {
+	_, simulated := strategy.(SimulationStrategy)
+	return simulated && cfg.ReassignInterval > 0
+}
+
+// reassignHallOrders returns who should serve each of orders among elevs. An
+// order in pinned stays with its elevator. An order held by exactly one
+// elevator stays there, unless moving orders serves all orders of elevs more
+// than hysteresis sooner in total. Orders count more the longer they have
+// waited, as in the cost of strategy. The result only depends on the
+// arguments, so every node computes the same assignment.
+func reassignHallOrders(strategy SimulationStrategy, elevs []esm.ElevData, orders []elevio.ButtonEvent, pinned assignment, waited map[elevio.ButtonEvent]time.Duration, hysteresis time.Duration) assignment {
+	elevs = append([]esm.ElevData(nil), elevs...)
+	sort.Slice(elevs, func(i, j int) bool { return elevs[i].ID < elevs[j].ID })
+
+	held := make(assignment)
+	for _, order := range orders {
+		var holders []string
+		for _, elev := range elevs {
+			if elev.LocalQueue[order.Button][order.Floor] == 1 {
+				holders = append(holders, elev.ID)
+			}
+		}
+		if len(holders) == 1 {
+			held[order] = holders[0]
+		}
+	}
+	fixed := make(assignment)
+	for order, owner := range pinned {
+		fixed[order] = owner
+		held[order] = owner
+	}
+
+	kept, keptCost := globalAssignment(strategy, elevs, orders, held, waited)
+	best, bestCost := globalAssignment(strategy, elevs, orders, fixed, waited)
+	if keptCost-bestCost > hysteresis {
+		return best
+	}
+	return kept
+}
+
+// globalAssignment assigns orders to elevs so the sum of the times to serve
+// every order of elevs, weighted by waited, is the lowest. Orders in fixed
+// keep their elevator. elevs must be sorted by ID, ties go to the highest ID.
+func globalAssignment(strategy SimulationStrategy, elevs []esm.ElevData, orders []elevio.ButtonEvent, fixed assignment, waited map[elevio.ButtonEvent]time.Duration) (assignment, time.Duration) {
+	// Each elevator is simulated with its cab orders and the hall orders
+	// assigned to it.
+	bases := make([]esm.ElevData, len(elevs))
+	for i, elev := range elevs {
+		bases[i] = elev
+		bases[i].LocalQueue = make([][]int, len(elev.LocalQueue))
+		for buttonNr := range elev.LocalQueue {
+			bases[i].LocalQueue[buttonNr] = append([]int(nil), elev.LocalQueue[buttonNr]...)
+		}
+		for _, order := range orders {
+			bases[i].LocalQueue[order.Button][order.Floor] = 0
+		}
+	}
+
+	assigned := make([][]elevio.ButtonEvent, len(elevs))
+	var free []elevio.ButtonEvent
+	for _, order := range orders {
+		owner := -1
+		for i, elev := range elevs {
+			if elev.ID == fixed[order] {
+				owner = i
+			}
+		}
+		if owner == -1 {
+			free = append(free, order)
+		} else {
+			assigned[owner] = append(assigned[owner], order)
+		}
+	}
+
+	// The exhaustive search asks for the same orders of an elevator many
+	// times, so the costs are remembered.
+	costs := make([]map[string]time.Duration, len(elevs))
+	for i := range costs {
+		costs[i] = make(map[string]time.Duration)
+	}
+	cost := func(i int, orders []elevio.ButtonEvent) time.Duration {
+		key := fmt.Sprint(orders)
+		if c, found := costs[i][key]; found {
+			return c
+		}
+		c := strategy.totalTime(bases[i], orders, waited)
+		costs[i][key] = c
+		return c
+	}
+
+	if len(elevs) > 0 && numAssignments(len(elevs), len(free)) <= maxExhaustiveAssignments {
+		assigned = exhaustiveAssignment(len(elevs), assigned, free, cost)
+	} else {
+		assigned = greedyAssignment(len(elevs), assigned, free, cost)
+	}
+
+	result := make(assignment)
+	total := time.Duration(0)
+	for i := range elevs {
+		for _, order := range assigned[i] {
+			result[order] = elevs[i].ID
+		}
+		total = addTimes(total, cost(i, assigned[i]))
+	}
+	return result, total
+}
+
+// exhaustiveAssignment tries every assignment of free on top of assigned and
+// returns the one with the lowest total cost.
+func exhaustiveAssignment(numElevs int, assigned [][]elevio.ButtonEvent, free []elevio.ButtonEvent, cost func(int, []elevio.ButtonEvent) time.Duration) [][]elevio.ButtonEvent {
+	var best [][]elevio.ButtonEvent
+	bestCost := never
+	// Choices are counted down from the highest elevator, so the first of
+	// equal assignments found favours the highest IDs.
+	choice := make([]int, len(free))
+	for k := range choice {
+		choice[k] = numElevs - 1
+	}
+	for {
+		candidate := copyAssigned(assigned)
+		for k, i := range choice {
+			candidate[i] = append(candidate[i], free[k])
+		}
+		candidateCost := time.Duration(0)
+		for i := range candidate {
+			candidateCost = addTimes(candidateCost, cost(i, candidate[i]))
+		}
+		if best == nil || candidateCost < bestCost {
+			best = candidate
+			bestCost = candidateCost
+		}
+
+		k := 0
+		for k < len(choice) && choice[k] == 0 {
+			choice[k] = numElevs - 1
+			k++
+		}
+		if k == len(choice) {
+			return best
+		}
+		choice[k]--
+	}
+}
+
+// greedyAssignment assigns free one order at a time to the elevator whose cost
+// increases the least.
+func greedyAssignment(numElevs int, assigned [][]elevio.ButtonEvent, free []elevio.ButtonEvent, cost func(int, []elevio.ButtonEvent) time.Duration) [][]elevio.ButtonEvent {
+	assigned = copyAssigned(assigned)
+	costs := make([]time.Duration, numElevs)
+	for i := range costs {
+		costs[i] = cost(i, assigned[i])
+	}
+	for _, order := range free {
+		bestElev := -1
+		bestIncrease := never
+		bestCost := never
+		for i := numElevs - 1; i >= 0; i-- {
+			newCost := cost(i, append(append([]elevio.ButtonEvent(nil), assigned[i]...), order))
+			if newCost == never {
+				continue
+			}
+			increase := newCost
+			if costs[i] != never {
+				increase = newCost - costs[i]
+			}
+			if increase < bestIncrease {
+				bestElev = i
+				bestIncrease = increase
+				bestCost = newCost
+			}
+		}
+		if bestElev == -1 {
+			continue
+		}
+		assigned[bestElev] = append(assigned[bestElev], order)
+		costs[bestElev] = bestCost
+	}
+	return assigned
+}
+
+// totalTime returns the sum of the times elev takes to serve each order in its
+// queue with orders added, or never if any of them is not served. The time of
+// a hall order grows by its penalty for having waited.
+func (s SimulationStrategy) totalTime(elev esm.ElevData, orders []elevio.ButtonEvent, waited map[elevio.ButtonEvent]time.Duration) time.Duration {
+	total := time.Duration(0)
+	for buttonNr, buttonTimes := range serveTimes(elev, orders, s.TravelTime, s.DoorTime) {
+		for floorNr, t := range buttonTimes {
+			t = addTimes(t, s.penalty(t, waited[elevio.MakeButtonEvent(buttonNr, floorNr)]))
+			total = addTimes(total, t)
+		}
+	}
+	return total
+}
+
+// addTimes adds a and b, where never plus anything is never.
+func addTimes(a, b time.Duration) time.Duration {
+	if a == never || b == never || a > never-b {
+		return never
+	}
+	return a + b
+}
+
+// numAssignments returns numElevs to the power of numOrders, stopping once it
+// exceeds maxExhaustiveAssignments.
+func numAssignments(numElevs, numOrders int) int {
+	n := 1
+	for k := 0; k < numOrders && n <= maxExhaustiveAssignments; k++ {
+		n *= numElevs
+	}
+	return n
+}
+
+func copyAssigned(assigned [][]elevio.ButtonEvent) [][]elevio.ButtonEvent {
+	assignedCopy := make([][]elevio.ButtonEvent, len(assigned))
+	for i := range assigned {
+		assignedCopy[i] = append([]elevio.ButtonEvent(nil), assigned[i]...)
+	}
+	return assignedCopy
+}
+
+// heldHallOrders returns the distributed hall orders held by an online
+// elevator. Orders held by nobody are left to their deadlines, see
+// missedDeadlines, so an order just served is not handed out again before it
+// is cleared.
+func heldHallOrders(elevData []esm.ElevData, distributedOrders [][]int) []elevio.ButtonEvent {
+	var orders []elevio.ButtonEvent
+	for buttonNr := 0; buttonNr < config.NumButtonTypes; buttonNr++ {
+		if buttonNr == elevio.BT_Cab {
+			continue
+		}
+		for floorNr := range distributedOrders[buttonNr] {
+			if distributedOrders[buttonNr][floorNr] != 1 {
+				continue
+			}
+			for _, elev := range elevData {
+				if (elev.Online || elev.ID == elevData[0].ID) && elev.LocalQueue[buttonNr][floorNr] == 1 {
+					orders = append(orders, elevio.MakeButtonEvent(buttonNr, floorNr))
+					break
+				}
+			}
+		}
+	}
+	return orders
+}
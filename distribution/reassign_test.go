@@ -0,0 +1,118 @@
+package distribution
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"../elevio"
+	"../esm"
+)
+
+// distanceCost is the cost of elevators standing at floors: the sum of the
+// distances to their orders, in seconds. An elevator at floor -1 serves
+// nothing.
+func distanceCost(floors []int) func(int, []elevio.ButtonEvent) time.Duration {
+	return func(i int, orders []elevio.ButtonEvent) time.Duration {
+		if floors[i] < 0 && len(orders) > 0 {
+			return never
+		}
+		total := time.Duration(0)
+		for _, order := range orders {
+			distance := order.Floor - floors[i]
+			if distance < 0 {
+				distance = -distance
+			}
+			total += time.Duration(distance) * time.Second
+		}
+		return total
+	}
+}
+
+func TestExhaustiveAndGreedyAssignment(t *testing.T) {
+	up := func(floor int) elevio.ButtonEvent { return elevio.ButtonEvent{Floor: floor, Button: elevio.BT_HallUp} }
+	for _, c := range []struct {
+		name     string
+		floors   []int
+		assigned [][]elevio.ButtonEvent
+		free     []elevio.ButtonEvent
+		want     [][]elevio.ButtonEvent
+	}{
+		{"nearest", []int{0, 3}, [][]elevio.ButtonEvent{nil, nil},
+			[]elevio.ButtonEvent{up(0), up(2), up(1)},
+			[][]elevio.ButtonEvent{{up(0), up(1)}, {up(2)}}},
+		{"assigned kept", []int{0, 3}, [][]elevio.ButtonEvent{nil, {up(0)}},
+			[]elevio.ButtonEvent{up(2)},
+			[][]elevio.ButtonEvent{nil, {up(0), up(2)}}},
+		{"ties to the highest", []int{1, 1}, [][]elevio.ButtonEvent{nil, nil},
+			[]elevio.ButtonEvent{up(0)},
+			[][]elevio.ButtonEvent{nil, {up(0)}}},
+		{"unavailable", []int{-1, 3}, [][]elevio.ButtonEvent{nil, nil},
+			[]elevio.ButtonEvent{up(0), up(1)},
+			[][]elevio.ButtonEvent{nil, {up(0), up(1)}}},
+	} {
+		cost := distanceCost(c.floors)
+		if got := exhaustiveAssignment(len(c.floors), c.assigned, c.free, cost); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: exhaustive assigned %v, want %v", c.name, got, c.want)
+		}
+		if got := greedyAssignment(len(c.floors), c.assigned, c.free, cost); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: greedy assigned %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestGlobalAssignment(t *testing.T) {
+	strategy := SimulationStrategy{TravelTime: 2 * time.Second, DoorTime: 3 * time.Second}
+	low := elevio.ButtonEvent{Floor: 0, Button: elevio.BT_HallUp}
+	high := elevio.ButtonEvent{Floor: 3, Button: elevio.BT_HallDown}
+	elevs := []esm.ElevData{
+		testElev("a", esm.Idle, 0, esm.HeadingUp),
+		testElev("b", esm.Idle, 3, esm.HeadingDown),
+	}
+
+	got, _ := globalAssignment(strategy, elevs, []elevio.ButtonEvent{low, high}, nil, nil)
+	if want := (assignment{low: "a", high: "b"}); !reflect.DeepEqual(got, want) {
+		t.Errorf("assigned %v, want %v", got, want)
+	}
+	got, _ = globalAssignment(strategy, elevs, []elevio.ButtonEvent{low, high}, assignment{high: "a"}, nil)
+	if got[high] != "a" {
+		t.Errorf("order fixed to a assigned to %s", got[high])
+	}
+}
+
+// An order held by an elevator is only moved to one serving it sooner when
+// that saves more than the hysteresis.
+func TestReassignHysteresis(t *testing.T) {
+	strategy := SimulationStrategy{TravelTime: 2 * time.Second, DoorTime: 3 * time.Second}
+	order := elevio.ButtonEvent{Floor: 2, Button: elevio.BT_HallUp}
+	orders := []elevio.ButtonEvent{order}
+	elevs := []esm.ElevData{
+		testElev("a", esm.Idle, 0, esm.HeadingUp, order),
+		testElev("b", esm.Idle, 2, esm.HeadingUp),
+	}
+
+	_, keptCost := globalAssignment(strategy, elevs, orders, assignment{order: "a"}, nil)
+	_, bestCost := globalAssignment(strategy, elevs, orders, nil, nil)
+	saved := keptCost - bestCost
+	if saved <= 0 {
+		t.Fatalf("moving the order to b saves %v", saved)
+	}
+
+	for _, c := range []struct {
+		hysteresis time.Duration
+		want       string
+	}{
+		{saved + time.Second, "a"},
+		{saved, "a"},
+		{saved - time.Millisecond, "b"},
+		{0, "b"},
+	} {
+		got := reassignHallOrders(strategy, elevs, orders, nil, nil, c.hysteresis)
+		if got[order] != c.want {
+			t.Errorf("hysteresis %v, saving %v: assigned to %s, want %s", c.hysteresis, saved, got[order], c.want)
+		}
+	}
+	if got := reassignHallOrders(strategy, elevs, orders, assignment{order: "a"}, nil, 0); got[order] != "a" {
+		t.Errorf("pinned order assigned to %s, want a", got[order])
+	}
+}
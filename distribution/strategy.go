@@ -0,0 +1,125 @@
+package distribution
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"../config"
+	"../elevio"
+	"../esm"
+)
+
+// Strategy decides which elevator should serve a hall order.
+type Strategy interface {
+	// Assign returns the ID of the elevator in elevs that should serve order.
+	// elevs holds only elevators able to take orders, and is never empty.
+	// waited holds how long the hall orders have waited, see waitTimes.
+	// Every node must reach the same answer from the same elevs and waited.
+	Assign(elevs []esm.ElevData, order elevio.ButtonEvent, waited map[elevio.ButtonEvent]time.Duration) string
+}
+
+// NewStrategy returns the strategy called name: "heuristic", "nearest" or
+// "simulation".
+func NewStrategy(name string, cfg config.Config) (Strategy, error) {
+	switch name {
+	case "heuristic":
+		return HeuristicStrategy{}, nil
+	case "nearest":
+		return NearestCarStrategy{}, nil
+	case "simulation":
+		return SimulationStrategy{
+			TravelTime:  cfg.TravelTimeDuration,
+			DoorTime:    cfg.DoorTimerDuration,
+			WaitPenalty: cfg.WaitPenaltyDuration,
+		}, nil
+	}
+	return nil, fmt.Errorf("distribution: unknown dispatch strategy %q", name)
+}
+
+// HeuristicStrategy assigns orders by GetCost. It ignores how long orders have
+// waited.
+type HeuristicStrategy struct{}
+
+func (HeuristicStrategy) Assign(elevs []esm.ElevData, order elevio.ButtonEvent, waited map[elevio.ButtonEvent]time.Duration) string {
+	return lowestCost(elevs, func(elev esm.ElevData) int64 {
+		return int64(GetCost(elev, order))
+	})
+}
+
+// NearestCarStrategy assigns orders to the elevator closest to the order
+// floor, regardless of its direction, its queue and how long orders have
+// waited.
+type NearestCarStrategy struct{}
+
+func (NearestCarStrategy) Assign(elevs []esm.ElevData, order elevio.ButtonEvent, waited map[elevio.ButtonEvent]time.Duration) string {
+	return lowestCost(elevs, func(elev esm.ElevData) int64 {
+		return int64(abs(elev.Floor - order.Floor))
+	})
+}
+
+// SimulationStrategy assigns orders to the elevator with the lowest
+// TimeToServe, penalised for delaying hall orders that have waited in its
+// queue.
+type SimulationStrategy struct {
+	TravelTime time.Duration
+	DoorTime   time.Duration
+	// WaitPenalty is the wait after which the time of a hall order counts
+	// double.
+	WaitPenalty time.Duration
+}
+
+func (s SimulationStrategy) Assign(elevs []esm.ElevData, order elevio.ButtonEvent, waited map[elevio.ButtonEvent]time.Duration) string {
+	return lowestCost(elevs, func(elev esm.ElevData) int64 {
+		return int64(s.cost(elev, order, waited))
+	})
+}
+
+// cost returns how long elev takes to serve order, plus the penalty for the
+// delay this adds to each hall order already in the queue of elev.
+func (s SimulationStrategy) cost(elev esm.ElevData, order elevio.ButtonEvent, waited map[elevio.ButtonEvent]time.Duration) time.Duration {
+	if !isAvailable(elev) {
+		return never
+	}
+	before := serveTimes(elev, nil, s.TravelTime, s.DoorTime)
+	after := serveTimes(elev, []elevio.ButtonEvent{order}, s.TravelTime, s.DoorTime)
+	cost := after[order.Button][order.Floor]
+	for buttonNr := range before {
+		for floorNr := range before[buttonNr] {
+			queued := elevio.MakeButtonEvent(buttonNr, floorNr)
+			if queued == order || before[buttonNr][floorNr] == never || after[buttonNr][floorNr] == never {
+				continue
+			}
+			if delay := after[buttonNr][floorNr] - before[buttonNr][floorNr]; delay > 0 {
+				cost = addTimes(cost, s.penalty(delay, waited[queued]))
+			}
+		}
+	}
+	return cost
+}
+
+// penalty returns how much more the time t of a hall order that has waited
+// wait counts: the share of s.WaitPenalty it has waited, so an order that has
+// waited s.WaitPenalty counts double.
+func (s SimulationStrategy) penalty(t time.Duration, wait time.Duration) time.Duration {
+	if wait <= 0 || s.WaitPenalty <= 0 || t == never {
+		return 0
+	}
+	return time.Duration(float64(t) * float64(wait) / float64(s.WaitPenalty))
+}
+
+// lowestCost returns the ID of the elevator with the lowest cost. Ties go to
+// the highest ID, so every node picks the same elevator.
+func lowestCost(elevs []esm.ElevData, cost func(esm.ElevData) int64) string {
+	bestElevID := ""
+	bestElevCost := int64(math.MaxInt64)
+	for _, elev := range elevs {
+		elevCost := cost(elev)
+		if elevCost < bestElevCost || (elevCost == bestElevCost && strings.Compare(elev.ID, bestElevID) == 1) {
+			bestElevID = elev.ID
+			bestElevCost = elevCost
+		}
+	}
+	return bestElevID
+}
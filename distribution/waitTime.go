@@ -0,0 +1,46 @@
+package distribution
+
+import (
+	"time"
+
+	"../elevio"
+	"../esm"
+)
+
+// waitTimes returns how long each confirmed hall order of self has waited
+// since its synced ConfirmedAt, rounded down to whole multiples of quantum.
+//
+// The ConfirmedAt of the nodes differ by the delay of the messages carrying
+// it, and with quantum set to the sync interval they mostly agree on the
+// wait. When they do not, the assignment consensus settles the owner.
+func waitTimes(self esm.ElevData, now time.Time, quantum time.Duration) map[elevio.ButtonEvent]time.Duration {
+	waited := make(map[elevio.ButtonEvent]time.Duration)
+	for buttonNr := 0; buttonNr < elevio.BT_Cab && buttonNr < len(self.ConfirmedAt) && buttonNr < len(self.OrderStatus); buttonNr++ {
+		for floorNr, confirmedAt := range self.ConfirmedAt[buttonNr] {
+			if confirmedAt == 0 || floorNr >= len(self.OrderStatus[buttonNr]) ||
+				self.OrderStatus[buttonNr][floorNr].Phase() != esm.OrderConfirmed {
+				continue
+			}
+			at := time.Unix(0, confirmedAt*int64(time.Millisecond))
+			if wait := now.Sub(at).Truncate(quantum); wait > 0 {
+				waited[elevio.MakeButtonEvent(buttonNr, floorNr)] = wait
+			}
+		}
+	}
+	return waited
+}
+
+// nearestIdleElevator returns the ID of the idle elevator among elevs closest
+// to order, or "" if none of them are idle.
+func nearestIdleElevator(elevs []esm.ElevData, order elevio.ButtonEvent) string {
+	var idle []esm.ElevData
+	for _, elev := range elevs {
+		if elev.State == esm.Idle {
+			idle = append(idle, elev)
+		}
+	}
+	if len(idle) == 0 {
+		return ""
+	}
+	return NearestCarStrategy{}.Assign(idle, order, nil)
+}
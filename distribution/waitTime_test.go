@@ -0,0 +1,80 @@
+package distribution
+
+import (
+	"testing"
+	"time"
+
+	"../config"
+	"../elevio"
+	"../esm"
+)
+
+func testElev(id string, state esm.ElevState, floor int, heading esm.HeadingDirection, orders ...elevio.ButtonEvent) esm.ElevData {
+	queue := make([][]int, config.NumButtonTypes)
+	for i := range queue {
+		queue[i] = make([]int, 4)
+	}
+	for _, order := range orders {
+		queue[order.Button][order.Floor] = 1
+	}
+	return esm.ElevData{ID: id, State: state, HeadingDir: heading, Floor: floor, LocalQueue: queue}
+}
+
+// confirmedAt returns the data of an elevator at which order is confirmed
+// since at.
+func confirmedAt(order elevio.ButtonEvent, at time.Time) esm.ElevData {
+	elev := testElev("a", esm.Idle, 0, esm.HeadingUp)
+	elev.OrderStatus = make([][]esm.OrderVersion, config.NumButtonTypes)
+	elev.ConfirmedAt = make([][]int64, config.NumButtonTypes)
+	for i := range elev.OrderStatus {
+		elev.OrderStatus[i] = make([]esm.OrderVersion, 4)
+		elev.ConfirmedAt[i] = make([]int64, 4)
+	}
+	elev.OrderStatus[order.Button][order.Floor] = 2
+	elev.ConfirmedAt[order.Button][order.Floor] = unixMillis(at)
+	return elev
+}
+
+// Nodes whose clocks differ by an hour, and whose synced ConfirmedAt differ by
+// the delay of the messages carrying it, agree on how long an order has
+// waited.
+func TestWaitTimesIgnoreClockSkew(t *testing.T) {
+	const syncInterval = 100 * time.Millisecond
+	order := elevio.ButtonEvent{Floor: 2, Button: elevio.BT_HallUp}
+	start := time.Unix(1000, 0)
+	skew := time.Hour
+
+	for _, delay := range []time.Duration{0, 10 * time.Millisecond, 40 * time.Millisecond} {
+		a := waitTimes(confirmedAt(order, start), start.Add(2050*time.Millisecond), syncInterval)
+		b := waitTimes(confirmedAt(order, start.Add(skew+delay)), start.Add(skew+2050*time.Millisecond), syncInterval)
+		if a[order] != 2*time.Second || b[order] != a[order] {
+			t.Errorf("synced %v apart: waits %v and %v, want both %v", delay, a[order], b[order], 2*time.Second)
+		}
+	}
+
+	unconfirmed := confirmedAt(order, start)
+	unconfirmed.OrderStatus[order.Button][order.Floor] = 1
+	if waited := waitTimes(unconfirmed, start.Add(time.Minute), syncInterval); len(waited) != 0 {
+		t.Errorf("unconfirmed order waited %v", waited[order])
+	}
+}
+
+// An elevator on its way to an order that has waited long is not sent to stop
+// for a new order on the way, when another elevator can take the new order.
+func TestSimulationStrategyWaitPenalty(t *testing.T) {
+	strategy := SimulationStrategy{TravelTime: 2 * time.Second, DoorTime: 3 * time.Second, WaitPenalty: 20 * time.Second}
+	waiting := elevio.ButtonEvent{Floor: 3, Button: elevio.BT_HallDown}
+	order := elevio.ButtonEvent{Floor: 1, Button: elevio.BT_HallUp}
+	elevs := []esm.ElevData{
+		testElev("a", esm.Moving, 0, esm.HeadingUp, waiting),
+		testElev("b", esm.Idle, 3, esm.HeadingDown),
+	}
+
+	if id := strategy.Assign(elevs, order, nil); id != "a" {
+		t.Errorf("assigned to %s without waits, want a passing the floor", id)
+	}
+	waited := map[elevio.ButtonEvent]time.Duration{waiting: 40 * time.Second}
+	if id := strategy.Assign(elevs, order, waited); id != "b" {
+		t.Errorf("assigned to %s, delaying an order that waited %v, want b", id, waited[waiting])
+	}
+}
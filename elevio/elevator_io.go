@@ -1,18 +1,10 @@
 package elevio
 
 import "time"
-import "sync"
-import "net"
-import "fmt"
 import "../config"
 
 const _pollRate = 20 * time.Millisecond
 
-var _initialized bool = false
-var _numFloors int = 4
-var _mtx sync.Mutex
-var _conn net.Conn
-
 type MotorDirection int
 
 const (
@@ -34,69 +26,47 @@ type ButtonEvent struct {
 	Button ButtonType
 }
 
-func Init(addr string, numFloors int) int {
-	if _initialized {
-		fmt.Println("Driver already initialized!")
-	} else {
-		_numFloors = numFloors
-		_mtx = sync.Mutex{}
-		var err error
-		_conn, err = net.Dial("tcp", addr)
-		if err != nil {
-			panic(err.Error())
-		}
-	}
+// Driver is the hardware surface of a single elevator: motor, lamps and
+// sensors. TCPDriver talks to the elevator server, other backends can be
+// swapped in to run several elevators in one process or to fake hardware.
+type Driver interface {
+	NumFloors() int
 
-	if getFloor() == -1 {
-		SetMotorDirection(MD_Down)
-		for getFloor() == -1 {
-			time.Sleep(_pollRate)
-		}
-		SetMotorDirection(MD_Stop)
-	}
+	SetMotorDirection(dir MotorDirection)
+	SetButtonLamp(button ButtonType, floor int, value bool)
+	SetFloorIndicator(floor int)
+	SetDoorOpenLamp(value bool)
+	SetStopLamp(value bool)
 
-	turnOffAllLights()
-	_initialized = true
-	return getFloor()
+	GetButton(button ButtonType, floor int) bool
+	GetFloor() int
+	GetStop() bool
+	GetObstruction() bool
 }
 
-func SetMotorDirection(dir MotorDirection) {
-	_mtx.Lock()
-	defer _mtx.Unlock()
-	_conn.Write([]byte{1, byte(dir), 0, 0})
-}
-
-func SetButtonLamp(button ButtonType, floor int, value bool) {
-	_mtx.Lock()
-	defer _mtx.Unlock()
-	_conn.Write([]byte{2, byte(button), byte(floor), toByte(value)})
-}
-
-func SetFloorIndicator(floor int) {
-	_mtx.Lock()
-	defer _mtx.Unlock()
-	_conn.Write([]byte{3, byte(floor), 0, 0})
-}
-
-func SetDoorOpenLamp(value bool) {
-	_mtx.Lock()
-	defer _mtx.Unlock()
-	_conn.Write([]byte{4, toByte(value), 0, 0})
-}
+// Init drives the elevator down to the nearest floor if it is between floors,
+// turns off all button lamps and returns the floor the elevator is at.
+func Init(driver Driver) int {
+	if driver.GetFloor() == -1 {
+		driver.SetMotorDirection(MD_Down)
+		for driver.GetFloor() == -1 {
+			time.Sleep(_pollRate)
+		}
+		driver.SetMotorDirection(MD_Stop)
+	}
 
-func SetStopLamp(value bool) {
-	_mtx.Lock()
-	defer _mtx.Unlock()
-	_conn.Write([]byte{5, toByte(value), 0, 0})
+	turnOffAllLights(driver)
+	return driver.GetFloor()
 }
 
-func PollButtons(receiver chan<- ButtonEvent) {
-	prev := make([][3]bool, _numFloors)
+func PollButtons(driver Driver, receiver chan<- ButtonEvent) {
+	numFloors := driver.NumFloors()
+	prev := make([][3]bool, numFloors)
 	for {
 		time.Sleep(_pollRate)
-		for f := 0; f < _numFloors; f++ {
+		for f := 0; f < numFloors; f++ {
 			for b := ButtonType(0); b < 3; b++ {
-				v := getButton(b, f)
+				v := driver.GetButton(b, f)
 				if v != prev[f][b] && v != false {
 					receiver <- ButtonEvent{f, ButtonType(b)}
 				}
@@ -106,11 +76,11 @@ func PollButtons(receiver chan<- ButtonEvent) {
 	}
 }
 
-func PollFloorSensor(receiver chan<- int) {
+func PollFloorSensor(driver Driver, receiver chan<- int) {
 	prev := -1
 	for {
 		time.Sleep(_pollRate)
-		v := getFloor()
+		v := driver.GetFloor()
 		if v != prev && v != -1 {
 			receiver <- v
 		}
@@ -118,11 +88,11 @@ func PollFloorSensor(receiver chan<- int) {
 	}
 }
 
-func PollStopButton(receiver chan<- bool) {
+func PollStopButton(driver Driver, receiver chan<- bool) {
 	prev := false
 	for {
 		time.Sleep(_pollRate)
-		v := getStop()
+		v := driver.GetStop()
 		if v != prev {
 			receiver <- v
 		}
@@ -130,11 +100,11 @@ func PollStopButton(receiver chan<- bool) {
 	}
 }
 
-func PollObstructionSwitch(receiver chan<- bool) {
+func PollObstructionSwitch(driver Driver, receiver chan<- bool) {
 	prev := false
 	for {
 		time.Sleep(_pollRate)
-		v := getObstruction()
+		v := driver.GetObstruction()
 		if v != prev {
 			receiver <- v
 		}
@@ -142,46 +112,6 @@ func PollObstructionSwitch(receiver chan<- bool) {
 	}
 }
 
-func getButton(button ButtonType, floor int) bool {
-	_mtx.Lock()
-	defer _mtx.Unlock()
-	_conn.Write([]byte{6, byte(button), byte(floor), 0})
-	var buf [4]byte
-	_conn.Read(buf[:])
-	return toBool(buf[1])
-}
-
-func getFloor() int {
-	_mtx.Lock()
-	defer _mtx.Unlock()
-	_conn.Write([]byte{7, 0, 0, 0})
-	var buf [4]byte
-	_conn.Read(buf[:])
-	if buf[1] != 0 {
-		return int(buf[2])
-	} else {
-		return -1
-	}
-}
-
-func getStop() bool {
-	_mtx.Lock()
-	defer _mtx.Unlock()
-	_conn.Write([]byte{8, 0, 0, 0})
-	var buf [4]byte
-	_conn.Read(buf[:])
-	return toBool(buf[1])
-}
-
-func getObstruction() bool {
-	_mtx.Lock()
-	defer _mtx.Unlock()
-	_conn.Write([]byte{9, 0, 0, 0})
-	var buf [4]byte
-	_conn.Read(buf[:])
-	return toBool(buf[1])
-}
-
 func toByte(a bool) byte {
 	var b byte = 0
 	if a {
@@ -208,11 +138,11 @@ func MakeButtonEvent(buttonNr int, floorNr int) ButtonEvent {
 	return order
 }
 
-func turnOffAllLights() {
-	for i := 0; i < config.NumFloors; i++ {
+func turnOffAllLights(driver Driver) {
+	for i := 0; i < driver.NumFloors(); i++ {
 		for k := 0; k < config.NumButtonTypes; k++ {
 			button := MakeButtonEvent(k, i)
-			SetButtonLamp(button.Button, button.Floor, false)
+			driver.SetButtonLamp(button.Button, button.Floor, false)
 		}
 	}
 }
@@ -0,0 +1,212 @@
+package elevio
+
+import "math"
+import "sync"
+import "time"
+
+const _simTickRate = 10 * time.Millisecond
+
+// _sensorWidth is how far from a floor, in floors, the floor sensor is active.
+const _sensorWidth = 0.1
+
+// SimDriver is an in-process Driver backend simulating a single elevator car,
+// so a node can run without the external elevator server. The car travels
+// between floors at a fixed time per floor while the motor is running. Inputs
+// (buttons, stop, obstruction) are set through the Press/Set methods, and the
+// lamp outputs can be read back for display.
+type SimDriver struct {
+	mtx        sync.Mutex
+	numFloors  int
+	travelTime time.Duration
+
+	position  float64
+	direction MotorDirection
+
+	buttons     [][3]bool
+	stop        bool
+	obstruction bool
+	motorLoss   bool
+
+	buttonLamps    [][3]bool
+	floorIndicator int
+	doorOpenLamp   bool
+	stopLamp       bool
+}
+
+// NewSimDriver creates a simulated elevator standing at the bottom floor and
+// starts moving the car in the background.
+func NewSimDriver(numFloors int, travelTime time.Duration) *SimDriver {
+	d := &SimDriver{
+		numFloors:   numFloors,
+		travelTime:  travelTime,
+		buttons:     make([][3]bool, numFloors),
+		buttonLamps: make([][3]bool, numFloors),
+	}
+	go d.run()
+	return d
+}
+
+func (d *SimDriver) run() {
+	step := float64(_simTickRate) / float64(d.travelTime)
+	for {
+		time.Sleep(_simTickRate)
+		d.mtx.Lock()
+		if !d.stop && !d.motorLoss {
+			d.position += float64(d.direction) * step
+			d.position = math.Max(0, math.Min(d.position, float64(d.numFloors-1)))
+		}
+		d.mtx.Unlock()
+	}
+}
+
+func (d *SimDriver) NumFloors() int {
+	return d.numFloors
+}
+
+func (d *SimDriver) SetMotorDirection(dir MotorDirection) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	d.direction = dir
+}
+
+func (d *SimDriver) SetButtonLamp(button ButtonType, floor int, value bool) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	if d.validButton(button, floor) {
+		d.buttonLamps[floor][button] = value
+	}
+}
+
+func (d *SimDriver) SetFloorIndicator(floor int) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	d.floorIndicator = floor
+}
+
+func (d *SimDriver) SetDoorOpenLamp(value bool) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	d.doorOpenLamp = value
+}
+
+func (d *SimDriver) SetStopLamp(value bool) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	d.stopLamp = value
+}
+
+func (d *SimDriver) GetButton(button ButtonType, floor int) bool {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	return d.validButton(button, floor) && d.buttons[floor][button]
+}
+
+func (d *SimDriver) GetFloor() int {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	nearest := math.Round(d.position)
+	if math.Abs(d.position-nearest) < _sensorWidth {
+		return int(nearest)
+	}
+	return -1
+}
+
+func (d *SimDriver) GetStop() bool {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	return d.stop
+}
+
+func (d *SimDriver) GetObstruction() bool {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	return d.obstruction
+}
+
+// PressButton holds a button down long enough for PollButtons to see it.
+func (d *SimDriver) PressButton(button ButtonType, floor int) {
+	d.SetButton(button, floor, true)
+	time.AfterFunc(3*_pollRate, func() { d.SetButton(button, floor, false) })
+}
+
+// SetButton sets whether a button is held down.
+func (d *SimDriver) SetButton(button ButtonType, floor int, pressed bool) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	if d.validButton(button, floor) {
+		d.buttons[floor][button] = pressed
+	}
+}
+
+// SetStop sets whether the stop button is held down. The car does not move
+// while it is.
+func (d *SimDriver) SetStop(pressed bool) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	d.stop = pressed
+}
+
+// SetMotorLoss sets whether the motor has lost power, so the car does not
+// move whatever direction it is given.
+func (d *SimDriver) SetMotorLoss(lost bool) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	d.motorLoss = lost
+}
+
+// MotorLoss returns whether the motor has lost power.
+func (d *SimDriver) MotorLoss() bool {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	return d.motorLoss
+}
+
+// SetObstruction sets the obstruction switch.
+func (d *SimDriver) SetObstruction(active bool) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	d.obstruction = active
+}
+
+// Position returns the car position in floors, e.g. 1.5 is halfway between
+// the second and third floor.
+func (d *SimDriver) Position() float64 {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	return d.position
+}
+
+// MotorDirection returns the direction last given to the motor.
+func (d *SimDriver) MotorDirection() MotorDirection {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	return d.direction
+}
+
+func (d *SimDriver) ButtonLamp(button ButtonType, floor int) bool {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	return d.validButton(button, floor) && d.buttonLamps[floor][button]
+}
+
+func (d *SimDriver) FloorIndicator() int {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	return d.floorIndicator
+}
+
+func (d *SimDriver) DoorOpenLamp() bool {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	return d.doorOpenLamp
+}
+
+func (d *SimDriver) StopLamp() bool {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	return d.stopLamp
+}
+
+func (d *SimDriver) validButton(button ButtonType, floor int) bool {
+	return floor >= 0 && floor < d.numFloors && button >= 0 && button < 3
+}
@@ -0,0 +1,81 @@
+// Package simcmd drives the inputs of an elevio.SimDriver with commands, one
+// per line:
+//
+//	up <floor>      press the hall up button at floor
+//	down <floor>    press the hall down button at floor
+//	cab <floor>     press the cab button for floor
+//	stop            toggle the stop button
+//	obstruct        toggle the obstruction switch
+//	motorloss       toggle power to the motor
+//	wait <duration> pause the script, e.g. "wait 1.5s"
+//
+// Lines starting with '#' are ignored.
+package simcmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"../../elevio"
+)
+
+// ReadCommands applies one command per line from input to car, until input
+// ends. Invalid commands are logged and skipped.
+func ReadCommands(input io.Reader, car *elevio.SimDriver) {
+	scanner := bufio.NewScanner(input)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := runCommand(strings.Fields(line), car); err != nil {
+			fmt.Println("simcmd:", err)
+		}
+	}
+}
+
+func runCommand(fields []string, car *elevio.SimDriver) error {
+	switch fields[0] {
+	case "up", "down", "cab":
+		if len(fields) != 2 {
+			return fmt.Errorf("usage: %s <floor>", fields[0])
+		}
+		floor, err := strconv.Atoi(fields[1])
+		if err != nil || floor < 0 || floor >= car.NumFloors() {
+			return fmt.Errorf("invalid floor %q", fields[1])
+		}
+		button := map[string]elevio.ButtonType{
+			"up":   elevio.BT_HallUp,
+			"down": elevio.BT_HallDown,
+			"cab":  elevio.BT_Cab,
+		}[fields[0]]
+		car.PressButton(button, floor)
+
+	case "stop":
+		car.SetStop(!car.GetStop())
+
+	case "obstruct":
+		car.SetObstruction(!car.GetObstruction())
+
+	case "motorloss":
+		car.SetMotorLoss(!car.MotorLoss())
+
+	case "wait":
+		if len(fields) != 2 {
+			return fmt.Errorf("usage: wait <duration>")
+		}
+		duration, err := time.ParseDuration(fields[1])
+		if err != nil {
+			return err
+		}
+		time.Sleep(duration)
+
+	default:
+		return fmt.Errorf("unknown command %q", fields[0])
+	}
+	return nil
+}
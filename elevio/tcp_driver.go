@@ -0,0 +1,99 @@
+package elevio
+
+import "sync"
+import "net"
+
+// TCPDriver is the Driver backend for the elevator server, using the 4-byte
+// command protocol over a TCP connection.
+type TCPDriver struct {
+	mtx       sync.Mutex
+	conn      net.Conn
+	numFloors int
+}
+
+// NewTCPDriver connects to the elevator server at addr. It panics if the
+// server can not be reached.
+func NewTCPDriver(addr string, numFloors int) *TCPDriver {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		panic(err.Error())
+	}
+	return &TCPDriver{
+		conn:      conn,
+		numFloors: numFloors,
+	}
+}
+
+func (d *TCPDriver) NumFloors() int {
+	return d.numFloors
+}
+
+func (d *TCPDriver) SetMotorDirection(dir MotorDirection) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	d.conn.Write([]byte{1, byte(dir), 0, 0})
+}
+
+func (d *TCPDriver) SetButtonLamp(button ButtonType, floor int, value bool) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	d.conn.Write([]byte{2, byte(button), byte(floor), toByte(value)})
+}
+
+func (d *TCPDriver) SetFloorIndicator(floor int) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	d.conn.Write([]byte{3, byte(floor), 0, 0})
+}
+
+func (d *TCPDriver) SetDoorOpenLamp(value bool) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	d.conn.Write([]byte{4, toByte(value), 0, 0})
+}
+
+func (d *TCPDriver) SetStopLamp(value bool) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	d.conn.Write([]byte{5, toByte(value), 0, 0})
+}
+
+func (d *TCPDriver) GetButton(button ButtonType, floor int) bool {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	d.conn.Write([]byte{6, byte(button), byte(floor), 0})
+	var buf [4]byte
+	d.conn.Read(buf[:])
+	return toBool(buf[1])
+}
+
+func (d *TCPDriver) GetFloor() int {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	d.conn.Write([]byte{7, 0, 0, 0})
+	var buf [4]byte
+	d.conn.Read(buf[:])
+	if buf[1] != 0 {
+		return int(buf[2])
+	} else {
+		return -1
+	}
+}
+
+func (d *TCPDriver) GetStop() bool {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	d.conn.Write([]byte{8, 0, 0, 0})
+	var buf [4]byte
+	d.conn.Read(buf[:])
+	return toBool(buf[1])
+}
+
+func (d *TCPDriver) GetObstruction() bool {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	d.conn.Write([]byte{9, 0, 0, 0})
+	var buf [4]byte
+	d.conn.Read(buf[:])
+	return toBool(buf[1])
+}
@@ -0,0 +1,235 @@
+package esm
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"../config"
+)
+
+// legacyBackupName is the backup file shared by all nodes before backups
+// were made per node.
+const legacyBackupName = "order_backup.txt"
+
+// backupVersion is the current version of the backup format. Files without a
+// version are the legacy format, a string of one digit per button and floor.
+const backupVersion = 1
+
+// Backup is the order backup file of one node in a state directory. The file
+// is locked while the Backup is open, so two processes can not use it at once.
+type Backup struct {
+	path string
+	lock *os.File
+}
+
+// OpenBackup creates stateDir if needed and locks the backup file of myID in it.
+func OpenBackup(stateDir string, myID string) (*Backup, error) {
+	if err := os.MkdirAll(stateDir, 0755); err != nil {
+		return nil, err
+	}
+	name := strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == ':' || r == os.PathSeparator {
+			return '_'
+		}
+		return r
+	}, myID)
+	path := filepath.Join(stateDir, "order_backup-"+name+".json")
+
+	lock, err := lockFile(path + ".lock")
+	if err != nil {
+		return nil, fmt.Errorf("esm: backup %s is in use by another process: %v", path, err)
+	}
+
+	// Backups used to be shared by all nodes in one file. The first node
+	// started after upgrading takes it over.
+	legacyPath := filepath.Join(stateDir, legacyBackupName)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		if os.Rename(legacyPath, path) == nil {
+			fmt.Println("esm: Took over shared backup ", legacyPath)
+		}
+	}
+	return &Backup{path: path, lock: lock}, nil
+}
+
+// Path returns the path of the backup file.
+func (b *Backup) Path() string {
+	return b.path
+}
+
+// Close releases the lock on the backup file.
+func (b *Backup) Close() {
+	unlockFile(b.lock)
+}
+
+// orderBackup is the local queue as stored on disk.
+type orderBackup struct {
+	Version        int
+	NumFloors      int
+	NumButtonTypes int
+	Queue          [][]int
+	Timestamp      time.Time
+	// Checksum is the CRC-32 of the backup encoded with Checksum set to 0.
+	Checksum uint32
+}
+
+func (b orderBackup) checksum() uint32 {
+	b.Checksum = 0
+	data, _ := json.Marshal(b)
+	return crc32.ChecksumIEEE(data)
+}
+
+// readBackupQueue returns the local queue saved at path. A missing file gives
+// an empty queue. Legacy backups are migrated, and are replaced by the current
+// format on the next write.
+func readBackupQueue(path string, numFloors int) ([][]int, error) {
+	queue := makeQueue(numFloors)
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return queue, nil
+	}
+	if err != nil {
+		return queue, err
+	}
+	if len(data) == 0 {
+		return queue, nil
+	}
+
+	if data[0] != '{' {
+		fmt.Println("esm: Migrating backup from legacy format: ", string(data))
+		return migrateLegacyBackup(data, numFloors)
+	}
+
+	var backup orderBackup
+	if err := json.Unmarshal(data, &backup); err != nil {
+		return queue, fmt.Errorf("esm: backup %s is corrupt: %v", path, err)
+	}
+	if backup.Version != backupVersion {
+		return queue, fmt.Errorf("esm: backup %s has unsupported version %d", path, backup.Version)
+	}
+	if backup.Checksum != backup.checksum() {
+		return queue, fmt.Errorf("esm: backup %s has wrong checksum", path)
+	}
+	if backup.NumFloors != numFloors || backup.NumButtonTypes != config.NumButtonTypes {
+		return queue, fmt.Errorf("esm: backup %s is for %d floors and %d button types, configured for %d floors and %d button types",
+			path, backup.NumFloors, backup.NumButtonTypes, numFloors, config.NumButtonTypes)
+	}
+	if !hasGeometry(backup.Queue, numFloors) {
+		return queue, fmt.Errorf("esm: backup %s queue does not match its geometry", path)
+	}
+	return backup.Queue, nil
+}
+
+// migrateLegacyBackup parses the legacy format, one digit per button and
+// floor with the hall up orders first.
+func migrateLegacyBackup(data []byte, numFloors int) ([][]int, error) {
+	queue := makeQueue(numFloors)
+	digits := string(data)
+	if len(digits) == numFloors*config.NumButtonTypes+1 {
+		digits = digits[:len(digits)-1]
+	}
+	if len(digits) != numFloors*config.NumButtonTypes {
+		return queue, fmt.Errorf("esm: legacy backup has %d orders, configured for %d floors and %d button types",
+			len(digits), numFloors, config.NumButtonTypes)
+	}
+	for i := 0; i < config.NumButtonTypes; i++ {
+		for k := 0; k < numFloors; k++ {
+			isOrder, err := strconv.Atoi(string(digits[i*numFloors+k]))
+			if err != nil || (isOrder != 0 && isOrder != 1) {
+				return makeQueue(numFloors), fmt.Errorf("esm: legacy backup is corrupt: %q", digits)
+			}
+			queue[i][k] = isOrder
+		}
+	}
+	return queue, nil
+}
+
+// backupQueue saves queue to path. The backup is written to a temporary file
+// which is synced and renamed over path, so a crash leaves either the old or
+// the new backup.
+func backupQueue(path string, queue [][]int) error {
+	backup := orderBackup{
+		Version:        backupVersion,
+		NumFloors:      len(queue[0]),
+		NumButtonTypes: len(queue),
+		Queue:          queue,
+		Timestamp:      time.Now(),
+	}
+	backup.Checksum = backup.checksum()
+	data, err := json.Marshal(backup)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(path)
+	tmp, err := ioutil.TempFile(dir, filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	// Sync the directory so the rename itself survives a crash.
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// saveBackup backs up queue to path, reporting failures without stopping the
+// elevator.
+func saveBackup(path string, queue [][]int) {
+	if err := backupQueue(path, queue); err != nil {
+		fmt.Println("esm: Could not back up queue: ", err)
+	}
+}
+
+// setAsideBackup renames an unusable backup so it is kept for inspection
+// instead of being overwritten.
+func setAsideBackup(path string) string {
+	invalidPath := fmt.Sprintf("%s.invalid-%d", path, time.Now().Unix())
+	os.Rename(path, invalidPath)
+	return invalidPath
+}
+
+func makeQueue(numFloors int) [][]int {
+	queue := make([][]int, config.NumButtonTypes)
+	for i := range queue {
+		queue[i] = make([]int, numFloors)
+	}
+	return queue
+}
+
+func hasGeometry(queue [][]int, numFloors int) bool {
+	if len(queue) != config.NumButtonTypes {
+		return false
+	}
+	for i := range queue {
+		if len(queue[i]) != numFloors {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,177 @@
+package esm
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testQueue() [][]int {
+	queue := makeQueue(testNumFloors)
+	queue[0][1] = 1
+	queue[2][3] = 1
+	return queue
+}
+
+func writeFile(t *testing.T, path string, data string) {
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBackupRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "backup.json")
+	empty := makeQueue(testNumFloors)
+	if queue, err := readBackupQueue(path, testNumFloors); err != nil || !reflect.DeepEqual(queue, empty) {
+		t.Errorf("missing backup read as %v, %v, want an empty queue", queue, err)
+	}
+	writeFile(t, path, "")
+	if queue, err := readBackupQueue(path, testNumFloors); err != nil || !reflect.DeepEqual(queue, empty) {
+		t.Errorf("empty backup read as %v, %v, want an empty queue", queue, err)
+	}
+
+	if err := backupQueue(path, testQueue()); err != nil {
+		t.Fatal(err)
+	}
+	if queue, err := readBackupQueue(path, testNumFloors); err != nil || !reflect.DeepEqual(queue, testQueue()) {
+		t.Errorf("backup read as %v, %v, want %v", queue, err, testQueue())
+	}
+	files, _ := filepath.Glob(path + ".tmp*")
+	if len(files) != 0 {
+		t.Errorf("temporary files %q left", files)
+	}
+}
+
+// Unusable backups give an error and an empty queue.
+func TestReadBackupErrors(t *testing.T) {
+	for _, c := range []struct {
+		name      string
+		numFloors int
+		// edit changes a valid backup, and the checksum is recomputed
+		// after it if reseal is set.
+		edit   func(b *orderBackup)
+		reseal bool
+		raw    string
+		err    string
+	}{
+		{name: "corrupt", raw: `{"Version":1,`, err: "corrupt"},
+		{name: "wrong checksum", edit: func(b *orderBackup) { b.Queue[1][2] = 1 }, err: "checksum"},
+		{name: "unsupported version", edit: func(b *orderBackup) { b.Version = backupVersion + 1 }, reseal: true, err: "version"},
+		{name: "other floor count", numFloors: testNumFloors + 2, err: "floors"},
+		{name: "other button types", edit: func(b *orderBackup) { b.NumButtonTypes = 2 }, reseal: true, err: "button types"},
+		{name: "geometry", edit: func(b *orderBackup) { b.Queue[1] = b.Queue[1][:2] }, reseal: true, err: "geometry"},
+	} {
+		path := filepath.Join(t.TempDir(), "backup.json")
+		data := c.raw
+		if data == "" {
+			if err := backupQueue(path, testQueue()); err != nil {
+				t.Fatal(err)
+			}
+			if c.edit != nil {
+				var backup orderBackup
+				raw, _ := ioutil.ReadFile(path)
+				if err := json.Unmarshal(raw, &backup); err != nil {
+					t.Fatal(err)
+				}
+				c.edit(&backup)
+				if c.reseal {
+					backup.Checksum = backup.checksum()
+				}
+				raw, _ = json.Marshal(backup)
+				data = string(raw)
+			}
+		}
+		if data != "" {
+			writeFile(t, path, data)
+		}
+		numFloors := c.numFloors
+		if numFloors == 0 {
+			numFloors = testNumFloors
+		}
+
+		queue, err := readBackupQueue(path, numFloors)
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%s: error %v, want one about %s", c.name, err, c.err)
+		}
+		if !reflect.DeepEqual(queue, makeQueue(numFloors)) {
+			t.Errorf("%s: queue %v, want an empty queue", c.name, queue)
+		}
+	}
+}
+
+func TestLegacyBackupMigration(t *testing.T) {
+	for _, c := range []struct {
+		name    string
+		data    string
+		want    [][]int
+		wantErr bool
+	}{
+		{"orders", "010000000001", testQueue(), false},
+		{"trailing newline", "010000000001\n", testQueue(), false},
+		{"too short", "01000000000", makeQueue(testNumFloors), true},
+		{"not a digit", "01000000000x", makeQueue(testNumFloors), true},
+		{"not an order", "010000000002", makeQueue(testNumFloors), true},
+	} {
+		dir := t.TempDir()
+		writeFile(t, filepath.Join(dir, legacyBackupName), c.data)
+		backup, err := OpenBackup(dir, "a/b")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, legacyBackupName)); !os.IsNotExist(err) {
+			t.Errorf("%s: shared backup not taken over", c.name)
+		}
+		queue, err := readBackupQueue(backup.Path(), testNumFloors)
+		backup.Close()
+		if (err != nil) != c.wantErr || !reflect.DeepEqual(queue, c.want) {
+			t.Errorf("%s: read as %v, %v, want %v and error %v", c.name, queue, err, c.want, c.wantErr)
+		}
+	}
+}
+
+// Only the first node started takes over the shared backup, and a node
+// started again keeps its own.
+func TestLegacyBackupTakenOverOnce(t *testing.T) {
+	dir := t.TempDir()
+	a, err := OpenBackup(dir, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := backupQueue(a.Path(), makeQueue(testNumFloors)); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(dir, legacyBackupName), "010000000001")
+	a.Close()
+
+	a, err = OpenBackup(dir, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	if queue, err := readBackupQueue(a.Path(), testNumFloors); err != nil || !reflect.DeepEqual(queue, makeQueue(testNumFloors)) {
+		t.Errorf("existing backup read as %v, %v after restarting, want an empty queue", queue, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, legacyBackupName)); err != nil {
+		t.Errorf("shared backup taken over by a node with a backup: %v", err)
+	}
+}
+
+func TestSetAsideBackup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "backup.json")
+	writeFile(t, path, "corrupt")
+
+	invalidPath := setAsideBackup(path)
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("backup still at %s", path)
+	}
+	if data, err := ioutil.ReadFile(invalidPath); err != nil || string(data) != "corrupt" {
+		t.Errorf("set aside backup read as %q, %v", data, err)
+	}
+	if queue, err := readBackupQueue(path, testNumFloors); err != nil || !reflect.DeepEqual(queue, makeQueue(testNumFloors)) {
+		t.Errorf("backup read as %v, %v after setting it aside, want an empty queue", queue, err)
+	}
+}
@@ -0,0 +1,20 @@
+// +build windows
+
+package esm
+
+import (
+	"syscall"
+	"time"
+)
+
+// processCPUTime returns the user and kernel CPU time used by the process.
+func processCPUTime() time.Duration {
+	var creation, exit, kernel, user syscall.Filetime
+	if err := syscall.GetProcessTimes(syscall.CurrentProcess(), &creation, &exit, &kernel, &user); err != nil {
+		return 0
+	}
+	// Filetimes count 100 ns intervals.
+	ticks := int64(kernel.HighDateTime)<<32 + int64(kernel.LowDateTime) +
+		int64(user.HighDateTime)<<32 + int64(user.LowDateTime)
+	return time.Duration(ticks * 100)
+}
@@ -2,11 +2,10 @@ package esm
 
 import (
 	"fmt"
-	"os"
 	"time"
 
 	"../config"
-	elevio "../elevio"
+	"../elevio"
 )
 
 // ElevState defines the state of the elevator.
@@ -21,8 +20,28 @@ const (
 	Moving
 	//DoorOpen defines an elevators state while at rest with the door open.
 	DoorOpen
+	//Emergency defines an elevator state while the stop button is held. The
+	//elevator stands still, keeps only its cab orders and resumes normal
+	//operation when the stop button is released.
+	Emergency
 )
 
+func (state ElevState) String() string {
+	switch state {
+	case Undefined:
+		return "Undefined"
+	case Idle:
+		return "Idle"
+	case Moving:
+		return "Moving"
+	case DoorOpen:
+		return "DoorOpen"
+	case Emergency:
+		return "Emergency"
+	}
+	return fmt.Sprintf("ElevState(%d)", int(state))
+}
+
 //HeadingDirection defines the moving direction of an elevator
 type HeadingDirection int
 
@@ -40,28 +59,51 @@ type ElevData struct {
 	State       ElevState
 	HeadingDir  HeadingDirection
 	Floor       int
-	OrderStatus [][]int
+	OrderStatus [][]OrderVersion
+	// ConfirmedAt holds when each order in OrderStatus was first registered
+	// by any elevator, in unix milliseconds by the local clock. 0 when there
+	// is no order. The clocks of the nodes may differ, so it is sent to the
+	// peers as the time since instead.
+	ConfirmedAt [][]int64
 	LocalQueue  [][]int
 	Online      bool
+	Obstructed  bool
+	MotorFault  MotorFault
+}
+
+// MotorFault describes a motor loss being recovered from. While the elevator
+// is Undefined the motor is retried, alternating between the heading direction
+// and the opposite direction. The zero value means no fault.
+type MotorFault struct {
+	// Floor is the last floor reached before the motor loss.
+	Floor int
+	// Retries is the number of times the motor has been retried.
+	Retries int
+	// Direction is the direction of the current retry.
+	Direction HeadingDirection
 }
 
 // Channels are channels used by esm to communiate with other modules.
 type Channels struct {
-	NewOrder        chan elevio.ButtonEvent
-	ArrivedAtFloor  chan int
-	WatchDogTimeOut chan bool
-	TurnOnLight     chan elevio.ButtonEvent
-	TurnOffLight    chan elevio.ButtonEvent
-	CompletedOrder  chan elevio.ButtonEvent
-	LocalElevData   chan ElevData
+	NewOrder       chan elevio.ButtonEvent
+	RevokedOrder   chan elevio.ButtonEvent
+	ArrivedAtFloor chan int
+	Obstruction    chan bool
+	StopButton     chan bool
+	TurnOnLight    chan elevio.ButtonEvent
+	TurnOffLight   chan elevio.ButtonEvent
+	CompletedOrder chan elevio.ButtonEvent
+	LocalElevData  chan ElevData
 }
 
-//ESM is state machine for completing given orders
-func ESM(channels Channels, initFloor int) {
+//ESM is state machine for completing given orders on the elevator behind driver.
+//The local queue is restored from and saved to backupFile. Transitions are
+//made by Step, ESM only waits for events and carries out the actions.
+func ESM(channels Channels, driver elevio.Driver, cfg config.Config, backupFile *Backup, initFloor int) {
 
 	localQueue := make([][]int, config.NumButtonTypes)
 	for i := range localQueue {
-		localQueue[i] = make([]int, config.NumFloors)
+		localQueue[i] = make([]int, cfg.NumFloors)
 	}
 
 	elevator := ElevData{
@@ -71,21 +113,24 @@ func ESM(channels Channels, initFloor int) {
 		LocalQueue: localQueue,
 	}
 
-	doorTimer := time.NewTimer(config.DoorTimerDuration * time.Second)
-	doorTimer.Stop()
-	watchDogTimer := time.NewTimer(config.WatchDogTimerDuration * time.Second)
-	watchDogTimer.Stop()
-	motorLossTimer := time.NewTimer(config.MotorLossTimerDuration * time.Second)
-	motorLossTimer.Stop()
-
-	// Local channels
-	closeDoor := make(chan bool)
-	openDoor := make(chan bool)
-	sendLocalData := make(chan bool)
+	e := executor{
+		channels:       channels,
+		driver:         driver,
+		cfg:            cfg,
+		backupFile:     backupFile,
+		doorTimer:      time.NewTimer(cfg.DoorTimerDuration),
+		motorLossTimer: time.NewTimer(cfg.MotorLossTimerDuration),
+	}
+	e.doorTimer.Stop()
+	e.motorLossTimer.Stop()
 
-	backup := readBackupQueue()
+	backup, err := readBackupQueue(backupFile.path, cfg.NumFloors)
+	if err != nil {
+		fmt.Println("\x1b[31;1m", err, "\x1b[0m")
+		fmt.Println("esm: Starting with an empty queue. Unusable backup moved to ", setAsideBackup(backupFile.path))
+	}
 	for i := 0; i < config.NumButtonTypes; i++ {
-		for k := 0; k < config.NumFloors; k++ {
+		for k := 0; k < cfg.NumFloors; k++ {
 			if backup[i][k] == 1 {
 				order := elevio.MakeButtonEvent(i, k)
 				go func() {
@@ -95,137 +140,111 @@ func ESM(channels Channels, initFloor int) {
 			}
 		}
 	}
-	backupFile, _ := os.Create("esm/order_backup.txt")
-	backupFile.Truncate(12)
-	defer backupFile.Close()
-
-	go func() { sendLocalData <- true }()
+	e.execute(elevator, []Action{{Type: SendLocalData}})
+
+	step := func(event Event) {
+		var actions []Action
+		prevState := elevator.State
+		elevator, actions = Step(elevator, event)
+		if elevator.State != prevState {
+			fmt.Println("Elevator state : ", elevator.State)
+		}
+		e.execute(elevator, actions)
+	}
 
 	for {
 		select {
 		case newOrder := <-channels.NewOrder:
 			fmt.Printf("Recieved new order: %+v\n", newOrder)
-			elevator.LocalQueue = addOrderToQueue(elevator.LocalQueue, newOrder)
-			backupQueue(backupFile, elevator.LocalQueue)
-			switch elevator.State {
-			case Idle:
-				if hasOrderAtCurrentFloor(elevator) {
-					go func() { openDoor <- true }()
-				} else {
-					elevator.HeadingDir = chooseHeadingDirection(elevator)
-					elevio.SetMotorDirection(getMotorDirection(elevator.HeadingDir))
-					elevator.State = Moving
-					watchDogTimer.Stop()
-					watchDogTimer.Reset(config.WatchDogTimerDuration * time.Second)
-					motorLossTimer.Stop()
-					motorLossTimer.Reset(time.Second * config.MotorLossTimerDuration)
-					fmt.Println("Elevator state : Moving")
-				}
-			case DoorOpen:
-				if shouldStop(elevator) {
-					go func() { openDoor <- true }()
-				}
-			default:
+			step(Event{Type: NewOrder, Order: newOrder})
 
-			}
-			go func() { sendLocalData <- true }()
+		case revokedOrder := <-channels.RevokedOrder:
+			fmt.Printf("Revoked order: %+v\n", revokedOrder)
+			step(Event{Type: RevokeOrder, Order: revokedOrder})
 
-		case elevator.Floor = <-channels.ArrivedAtFloor:
-			elevio.SetFloorIndicator(elevator.Floor)
-			motorLossTimer.Stop()
-			motorLossTimer.Reset(time.Second * config.MotorLossTimerDuration)
+		case floor := <-channels.ArrivedAtFloor:
+			step(Event{Type: ArrivedAtFloor, Floor: floor})
 
-			if elevator.Floor == config.NumFloors-1 {
-				elevator.HeadingDir = HeadingDown
-			}
-			if elevator.Floor == 0 {
-				elevator.HeadingDir = HeadingUp
-			}
-			if elevator.State == Undefined {
-				elevator.State = Moving
-				motorLossTimer.Stop()
-				motorLossTimer.Reset(time.Second * config.MotorLossTimerDuration)
-			}
+		case obstructed := <-channels.Obstruction:
+			fmt.Println("Obstruction: ", obstructed)
+			step(Event{Type: ObstructionChanged, Active: obstructed})
 
-			if shouldStop(elevator) {
-				go func() { openDoor <- true }()
-			}
-			go func() { sendLocalData <- true }()
+		case stopPressed := <-channels.StopButton:
+			fmt.Println("Stop button pressed: ", stopPressed)
+			step(Event{Type: StopButtonChanged, Active: stopPressed, AtFloor: driver.GetFloor() != -1})
 
-		case <-closeDoor:
-			elevator.HeadingDir = chooseHeadingDirection(elevator)
-			if shouldStop(elevator) && hasOrderAtCurrentFloor(elevator) {
-				go func() { openDoor <- true }()
-				break
-			}
-			fmt.Println("Closing door ")
-			watchDogTimer.Stop()
-			watchDogTimer.Reset(config.WatchDogTimerDuration * time.Second)
-			elevio.SetDoorOpenLamp(false)
-
-			if !shouldStop(elevator) {
-				elevator.State = Moving
-				elevio.SetMotorDirection(getMotorDirection(elevator.HeadingDir))
-				motorLossTimer.Stop()
-				motorLossTimer.Reset(time.Second * config.MotorLossTimerDuration)
-			} else {
-				elevator.State = Idle
-			}
-			go func() { sendLocalData <- true }()
-
-		case <-openDoor:
-			fmt.Println("Open door ")
-			elevator.State = DoorOpen
-			elevio.SetMotorDirection(elevio.MD_Stop)
-			elevio.SetDoorOpenLamp(true)
-
-			for i := 0; i < config.NumButtonTypes; i++ {
-				order := elevio.MakeButtonEvent(i, elevator.Floor)
-				if shouldClearOrder(elevator, order) {
-					println("Sending completed order: Button: ", order.Button, " Floor:", order.Floor)
-					elevator.LocalQueue[order.Button][order.Floor] = 0
-					go func() { channels.CompletedOrder <- order }()
-				}
-			}
-			elevator.LocalQueue[elevio.BT_Cab][elevator.Floor] = 0
-			turnOffLightsOfClearedOrders(elevator)
-			backupQueue(backupFile, elevator.LocalQueue)
-
-			doorTimer.Reset(config.DoorTimerDuration * time.Second)
-			watchDogTimer.Stop()
-			watchDogTimer.Reset(config.WatchDogTimerDuration * time.Second)
-
-			motorLossTimer.Stop()
-			go func() { sendLocalData <- true }()
-
-		case <-doorTimer.C:
+		case <-e.doorTimer.C:
 			fmt.Println("Door Timeout")
-			go func() { closeDoor <- true }()
+			step(Event{Type: DoorTimeout})
 
-		case <-watchDogTimer.C:
-			fmt.Println("ESM: WatchDogTimer Timeout")
+		case <-e.motorLossTimer.C:
+			println("MotorLosstimer Timeout.")
+			step(Event{Type: MotorLossTimeout})
+
+		case order := <-channels.TurnOnLight:
+			driver.SetButtonLamp(order.Button, order.Floor, true)
 
-			watchDogTimer.Reset(time.Second * config.WatchDogTimerDuration)
-			channels.WatchDogTimeOut <- true
+		case order := <-channels.TurnOffLight:
+			driver.SetButtonLamp(order.Button, order.Floor, false)
+		}
+	}
+}
 
-		case <-motorLossTimer.C:
-			println("MotorLosstimer Timeout.\nelevator.State = Undefined")
-			elevator.State = Undefined
-			go func() { sendLocalData <- true }()
+// executor carries out the actions returned by Step.
+type executor struct {
+	channels       Channels
+	driver         elevio.Driver
+	cfg            config.Config
+	backupFile     *Backup
+	doorTimer      *time.Timer
+	motorLossTimer *time.Timer
+}
 
-		case <-sendLocalData:
+// execute carries out actions in order. elevator is the state after the
+// transition that returned them.
+func (e *executor) execute(elevator ElevData, actions []Action) {
+	for _, action := range actions {
+		switch action.Type {
+		case SetMotorDirection:
+			e.driver.SetMotorDirection(action.Motor)
+		case SetDoorOpenLamp:
+			if action.Value {
+				fmt.Println("Open door ")
+			} else {
+				fmt.Println("Closing door ")
+			}
+			e.driver.SetDoorOpenLamp(action.Value)
+		case SetFloorIndicator:
+			e.driver.SetFloorIndicator(action.Floor)
+		case SetStopLamp:
+			e.driver.SetStopLamp(action.Value)
+		case SetButtonLamp:
+			e.driver.SetButtonLamp(action.Order.Button, action.Order.Floor, action.Value)
+		case StartDoorTimer:
+			e.doorTimer.Reset(e.cfg.DoorTimerDuration)
+		case StopDoorTimer:
+			e.doorTimer.Stop()
+		case StartMotorLossTimer:
+			e.motorLossTimer.Reset(e.cfg.MotorLossTimerDuration)
+		case StopMotorLossTimer:
+			e.motorLossTimer.Stop()
+		case ReportMotorFault:
+			if action.Fault.Retries == 0 {
+				fmt.Println("\x1b[32;1m", "esm: Motor recovered at floor ", elevator.Floor, "\x1b[0m")
+			} else {
+				fmt.Printf("\x1b[31;1m esm: Motor fault: %+v \x1b[0m\n", action.Fault)
+			}
+		case CompleteOrder:
+			order := action.Order
+			println("Sending completed order: Button: ", order.Button, " Floor:", order.Floor)
+			go func() { e.channels.CompletedOrder <- order }()
+		case BackupQueue:
+			saveBackup(e.backupFile.path, elevator.LocalQueue)
+		case SendLocalData:
 			var copyData ElevData
 			DeepCopy(&copyData, &elevator)
 			fmt.Println("Sending localData from esm: ", copyData)
-			go func() { channels.LocalElevData <- copyData }()
-
-		case order := <-channels.TurnOnLight:
-			elevio.SetButtonLamp(order.Button, order.Floor, true)
-
-		case order := <-channels.TurnOffLight:
-			elevio.SetButtonLamp(order.Button, order.Floor, false)
-
-		default:
+			go func() { e.channels.LocalElevData <- copyData }()
 		}
 	}
 }
@@ -2,10 +2,6 @@ package esm
 
 import (
 	"encoding/json"
-	"fmt"
-	"io/ioutil"
-	"os"
-	"strconv"
 
 	"../config"
 	"../elevio"
@@ -17,51 +13,6 @@ func DeepCopy(target, source interface{}) {
 	json.Unmarshal(n, target)
 }
 
-func readBackupQueue() [][]int {
-	queue := make([][]int, config.NumButtonTypes)
-	for i := range queue {
-		queue[i] = make([]int, config.NumFloors)
-	}
-
-	//backup is previous elevator.LocalQueue saved from file
-	backup, err := ioutil.ReadFile("esm/order_backup.txt")
-	if err != nil {
-		println("esm: Could not read from file. Creating new file.")
-		os.Create("esm/order_backup.txt")
-	} else {
-		println("esm: Read from file: ", string(backup))
-		// If backup is formatted correctly we add it to backupQueue
-		if len(string(backup)) == config.NumFloors*config.NumButtonTypes+1 || len(string(backup)) == config.NumFloors*config.NumButtonTypes {
-			println("String length correct")
-			for i := 0; i < config.NumButtonTypes; i++ {
-				for k := 0; k < config.NumFloors; k++ {
-					isOrder, _ := strconv.Atoi(string(backup[i*config.NumFloors+k]))
-					if isOrder == 1 || isOrder == 0 {
-						queue[i][k] = isOrder
-					}
-				}
-			}
-		}
-	}
-	return queue
-}
-
-func backupQueue(file *os.File, queue [][]int) {
-	queueString := ""
-	file.Seek(0, 0)
-
-	for i := range queue {
-		for k := range queue[i] {
-			order := strconv.Itoa(queue[i][k])
-			queueString += order
-		}
-	}
-	//println("Backing up queue: ", queueString)
-	//n, _ := file.WriteString(queueString)
-	file.WriteString(queueString)
-	//println("Backed up ", n, "bytes of data")
-}
-
 func chooseHeadingDirection(elev ElevData) HeadingDirection {
 	switch elev.HeadingDir {
 	case HeadingUp:
@@ -74,7 +25,7 @@ func chooseHeadingDirection(elev ElevData) HeadingDirection {
 	case HeadingDown:
 		if HasOrderBelow(elev) ||
 			hasHallDownOrderAtCurrentFloor(elev) ||
-			elev.Floor == config.NumFloors-1 {
+			elev.Floor == numFloors(elev)-1 {
 			return HeadingDown
 		}
 		return HeadingUp
@@ -103,9 +54,14 @@ func shouldStop(elev ElevData) bool {
 	return false
 }
 
+// numFloors returns the number of floors in the building elev serves.
+func numFloors(elev ElevData) int {
+	return len(elev.LocalQueue[elevio.BT_Cab])
+}
+
 //hasOrderAbove returns whether an elev has a local order above last known floor
 func hasOrderAbove(elev ElevData) bool {
-	for floor := elev.Floor + 1; floor < config.NumFloors; floor++ {
+	for floor := elev.Floor + 1; floor < numFloors(elev); floor++ {
 		for button := 0; button < config.NumButtonTypes; button++ {
 			if elev.LocalQueue[button][floor] == 1 {
 				return true
@@ -140,7 +96,6 @@ func hasHallUpOrderAtCurrentFloor(elev ElevData) bool {
 }
 
 func hasCabOrderAtCurrentFloor(elev ElevData) bool {
-	fmt.Println(elev)
 	return elev.LocalQueue[elevio.BT_Cab][elev.Floor] == 1
 }
 
@@ -153,6 +108,15 @@ func addOrderToQueue(queue [][]int, newOrder elevio.ButtonEvent) [][]int {
 	return queue
 }
 
+// removeHallOrders removes all hall orders from queue, keeping the cab orders.
+func removeHallOrders(queue [][]int) [][]int {
+	for floor := range queue[elevio.BT_HallUp] {
+		queue[elevio.BT_HallUp][floor] = 0
+		queue[elevio.BT_HallDown][floor] = 0
+	}
+	return queue
+}
+
 // ShoudClearOrder return true if an order
 func shouldClearOrder(elev ElevData, order elevio.ButtonEvent) bool {
 	switch order.Button {
@@ -185,18 +149,21 @@ func shouldClearOrder(elev ElevData, order elevio.ButtonEvent) bool {
 	return false
 }
 
-func turnOffLightsOfClearedOrders(elev ElevData) {
+// lightsOfClearedOrders returns the actions turning off the lights of the
+// orders cleared when stopping at the current floor.
+func lightsOfClearedOrders(elev ElevData) []Action {
 	//Clearing cab order
-	elevio.SetButtonLamp(elevio.BT_Cab, elev.Floor, false)
+	actions := []Action{{Type: SetButtonLamp, Order: elevio.ButtonEvent{Floor: elev.Floor, Button: elevio.BT_Cab}}}
 
 	//Clearing prioritized hall order
 	switch elev.HeadingDir {
 	case HeadingUp:
-		elevio.SetButtonLamp(elevio.BT_HallUp, elev.Floor, false)
+		actions = append(actions, Action{Type: SetButtonLamp, Order: elevio.ButtonEvent{Floor: elev.Floor, Button: elevio.BT_HallUp}})
 	case HeadingDown:
-		elevio.SetButtonLamp(elevio.BT_HallDown, elev.Floor, false)
+		actions = append(actions, Action{Type: SetButtonLamp, Order: elevio.ButtonEvent{Floor: elev.Floor, Button: elevio.BT_HallDown}})
 	default:
 	}
+	return actions
 }
 
 func getMotorDirection(headingDirection HeadingDirection) elevio.MotorDirection {
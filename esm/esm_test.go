@@ -0,0 +1,211 @@
+package esm
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"../config"
+	"../elevio"
+)
+
+// fakeDriver is an elevio.Driver recording the motor and door lamp settings
+// made by ESM, and counting all calls.
+type fakeDriver struct {
+	numFloors int
+	floor     int32
+	calls     int64
+	motor     chan elevio.MotorDirection
+	doorLamp  chan bool
+}
+
+func newFakeDriver(numFloors int) *fakeDriver {
+	return &fakeDriver{
+		numFloors: numFloors,
+		motor:     make(chan elevio.MotorDirection, 16),
+		doorLamp:  make(chan bool, 16),
+	}
+}
+
+func (d *fakeDriver) called() { atomic.AddInt64(&d.calls, 1) }
+
+func (d *fakeDriver) NumFloors() int { return d.numFloors }
+
+func (d *fakeDriver) SetMotorDirection(dir elevio.MotorDirection) {
+	d.called()
+	d.motor <- dir
+}
+
+func (d *fakeDriver) SetButtonLamp(button elevio.ButtonType, floor int, value bool) { d.called() }
+
+func (d *fakeDriver) SetFloorIndicator(floor int) {
+	d.called()
+	atomic.StoreInt32(&d.floor, int32(floor))
+}
+
+func (d *fakeDriver) SetDoorOpenLamp(value bool) {
+	d.called()
+	d.doorLamp <- value
+}
+
+func (d *fakeDriver) SetStopLamp(value bool) { d.called() }
+
+func (d *fakeDriver) GetButton(button elevio.ButtonType, floor int) bool { return false }
+
+func (d *fakeDriver) GetFloor() int { return int(atomic.LoadInt32(&d.floor)) }
+
+func (d *fakeDriver) GetStop() bool { return false }
+
+func (d *fakeDriver) GetObstruction() bool { return false }
+
+// esmEnv is an ESM running on a fakeDriver. The data and completed orders it
+// sends are counted and discarded.
+type esmEnv struct {
+	tb        testing.TB
+	driver    *fakeDriver
+	channels  Channels
+	localData int64
+}
+
+func startESM(tb testing.TB) *esmEnv {
+	cfg := config.Default()
+	cfg.DoorTimerDuration = time.Millisecond
+	backup, err := OpenBackup(tb.TempDir(), "test")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	env := &esmEnv{
+		tb:     tb,
+		driver: newFakeDriver(cfg.NumFloors),
+		channels: Channels{
+			NewOrder:       make(chan elevio.ButtonEvent),
+			RevokedOrder:   make(chan elevio.ButtonEvent),
+			ArrivedAtFloor: make(chan int),
+			Obstruction:    make(chan bool),
+			StopButton:     make(chan bool),
+			TurnOnLight:    make(chan elevio.ButtonEvent),
+			TurnOffLight:   make(chan elevio.ButtonEvent),
+			CompletedOrder: make(chan elevio.ButtonEvent),
+			LocalElevData:  make(chan ElevData),
+		},
+	}
+	go func() {
+		for {
+			select {
+			case <-env.channels.LocalElevData:
+				atomic.AddInt64(&env.localData, 1)
+			case <-env.channels.CompletedOrder:
+			}
+		}
+	}()
+	go ESM(env.channels, env.driver, cfg, backup, 0)
+	return env
+}
+
+func (env *esmEnv) expectMotor(want elevio.MotorDirection) {
+	select {
+	case dir := <-env.driver.motor:
+		if dir != want {
+			env.tb.Fatalf("motor set to %d, want %d", dir, want)
+		}
+	case <-time.After(time.Second):
+		env.tb.Fatalf("motor not set to %d", want)
+	}
+}
+
+func (env *esmEnv) expectDoorLamp(want bool) {
+	select {
+	case value := <-env.driver.doorLamp:
+		if value != want {
+			env.tb.Fatalf("door lamp set to %v, want %v", value, want)
+		}
+	case <-time.After(time.Second):
+		env.tb.Fatalf("door lamp not set to %v", want)
+	}
+}
+
+// travel takes the idle elevator at from to the cab order at to, and waits
+// for the door to close there. It returns how long the motor took to start
+// after the order was given, and to stop after the floor was reached.
+func (env *esmEnv) travel(from int, to int) (toStart time.Duration, toStop time.Duration) {
+	dir := elevio.MD_Up
+	if to < from {
+		dir = elevio.MD_Down
+	}
+	start := time.Now()
+	env.channels.NewOrder <- elevio.ButtonEvent{Floor: to, Button: elevio.BT_Cab}
+	env.expectMotor(dir)
+	toStart = time.Since(start)
+
+	start = time.Now()
+	env.channels.ArrivedAtFloor <- to
+	env.expectMotor(elevio.MD_Stop)
+	toStop = time.Since(start)
+
+	env.expectDoorLamp(true)
+	env.expectDoorLamp(false)
+	return toStart, toStop
+}
+
+func TestESMServesCabOrders(t *testing.T) {
+	env := startESM(t)
+	floor := 0
+	for _, to := range []int{2, 3, 0, 1} {
+		toStart, toStop := env.travel(floor, to)
+		t.Logf("floor %d to %d: motor started after %v, stopped after %v", floor, to, toStart, toStop)
+		floor = to
+	}
+}
+
+// An idle elevator blocks on its channels and timers. It must neither use the
+// driver nor keep sending its data.
+func TestESMIdle(t *testing.T) {
+	env := startESM(t)
+	env.travel(0, 1)
+	time.Sleep(10 * time.Millisecond)
+
+	calls := atomic.LoadInt64(&env.driver.calls)
+	localData := atomic.LoadInt64(&env.localData)
+	cpu := processCPUTime()
+	const idle = 500 * time.Millisecond
+	time.Sleep(idle)
+	used := processCPUTime() - cpu
+
+	if n := atomic.LoadInt64(&env.driver.calls) - calls; n != 0 {
+		t.Errorf("%d driver calls while idle", n)
+	}
+	if n := atomic.LoadInt64(&env.localData) - localData; n != 0 {
+		t.Errorf("local data sent %d times while idle", n)
+	}
+	t.Logf("CPU time used while idle for %v: %v", idle, used)
+	if used > idle/10 {
+		t.Errorf("used %v of CPU time while idle for %v", used, idle)
+	}
+}
+
+// benchmarkLatency moves the elevator back and forth between the two lowest
+// floors, and reports the latency picked by latency from the durations
+// returned by travel as ns/event. ns/op is the time of a whole trip.
+func benchmarkLatency(b *testing.B, latency func(toStart time.Duration, toStop time.Duration) time.Duration) {
+	env := startESM(b)
+	var total time.Duration
+	floor := 0
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		total += latency(env.travel(floor, 1-floor))
+		floor = 1 - floor
+	}
+	b.ReportMetric(float64(total.Nanoseconds())/float64(b.N), "ns/event")
+}
+
+// BenchmarkNewOrderLatency measures the time from a NewOrder to the motor
+// starting.
+func BenchmarkNewOrderLatency(b *testing.B) {
+	benchmarkLatency(b, func(toStart time.Duration, toStop time.Duration) time.Duration { return toStart })
+}
+
+// BenchmarkArrivedAtFloorLatency measures the time from an ArrivedAtFloor at
+// an ordered floor to the motor stopping.
+func BenchmarkArrivedAtFloorLatency(b *testing.B) {
+	benchmarkLatency(b, func(toStart time.Duration, toStop time.Duration) time.Duration { return toStop })
+}
@@ -0,0 +1,19 @@
+// +build windows
+
+package esm
+
+import (
+	"os"
+)
+
+// lockFile creates path, failing if it already exists. Unlike on other
+// platforms the lock file is left behind if the process is killed, and must
+// then be removed by hand.
+func lockFile(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_RDWR, 0644)
+}
+
+func unlockFile(file *os.File) {
+	file.Close()
+	os.Remove(file.Name())
+}
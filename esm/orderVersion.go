@@ -0,0 +1,42 @@
+package esm
+
+// OrderVersion is the synchronized state of an order. It only ever counts up,
+// cycling through the phases none, unconfirmed, confirmed and served, so two
+// versions of the same order are merged by taking the highest.
+type OrderVersion uint64
+
+// OrderPhase defines the phase of an order in its cycle.
+type OrderPhase int
+
+const (
+	//OrderNone is no order.
+	OrderNone OrderPhase = iota
+	//OrderUnconfirmed is an order not yet seen by every online elevator.
+	OrderUnconfirmed
+	//OrderConfirmed is an order seen by every online elevator, to be served.
+	OrderConfirmed
+	//OrderServed is a served order not yet known as served by every online
+	//elevator.
+	OrderServed
+)
+
+const numOrderPhases = 4
+
+// Phase returns the phase of the order.
+func (version OrderVersion) Phase() OrderPhase {
+	return OrderPhase(version % numOrderPhases)
+}
+
+// Cycle returns how many times the order has been cleared. Versions in the same
+// cycle belong to the same order.
+func (version OrderVersion) Cycle() uint64 {
+	return uint64(version / numOrderPhases)
+}
+
+// MergeOrderVersions returns the merge of two versions of the same order.
+func MergeOrderVersions(a, b OrderVersion) OrderVersion {
+	if a > b {
+		return a
+	}
+	return b
+}
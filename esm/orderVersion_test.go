@@ -0,0 +1,82 @@
+package esm
+
+import (
+	"testing"
+	"testing/quick"
+)
+
+func TestMergeOrderVersionsLaws(t *testing.T) {
+	commutative := func(a, b OrderVersion) bool {
+		return MergeOrderVersions(a, b) == MergeOrderVersions(b, a)
+	}
+	associative := func(a, b, c OrderVersion) bool {
+		return MergeOrderVersions(MergeOrderVersions(a, b), c) == MergeOrderVersions(a, MergeOrderVersions(b, c))
+	}
+	idempotent := func(a OrderVersion) bool {
+		return MergeOrderVersions(a, a) == a
+	}
+	monotonic := func(a, b OrderVersion) bool {
+		merged := MergeOrderVersions(a, b)
+		return merged >= a && merged >= b
+	}
+	for name, property := range map[string]interface{}{
+		"commutative": commutative,
+		"associative": associative,
+		"idempotent":  idempotent,
+		"monotonic":   monotonic,
+	} {
+		if err := quick.Check(property, nil); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+// Merging versions sent by peers converges to the highest version once every
+// version has arrived at least once, however the versions before were lost,
+// repeated or reordered, and the merged version never goes back.
+func TestMergeOrderVersionsConverge(t *testing.T) {
+	converges := func(sent []OrderVersion, arrivals []uint8) bool {
+		highest := OrderVersion(0)
+		for _, version := range sent {
+			highest = MergeOrderVersions(highest, version)
+		}
+
+		merged := OrderVersion(0)
+		merge := func(version OrderVersion) bool {
+			next := MergeOrderVersions(merged, version)
+			if next < merged {
+				return false
+			}
+			merged = next
+			return true
+		}
+		// Lossy, repeating and reordering delivery picks arbitrary versions.
+		for _, arrival := range arrivals {
+			if len(sent) > 0 && !merge(sent[int(arrival)%len(sent)]) {
+				return false
+			}
+		}
+		// Retransmission eventually delivers every version.
+		for i := len(sent) - 1; i >= 0; i-- {
+			if !merge(sent[i]) {
+				return false
+			}
+		}
+		return merged == highest
+	}
+	if err := quick.Check(converges, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestOrderVersionPhases(t *testing.T) {
+	phases := []OrderPhase{OrderNone, OrderUnconfirmed, OrderConfirmed, OrderServed}
+	for version := OrderVersion(0); version < 3*numOrderPhases; version++ {
+		if phase := version.Phase(); phase != phases[version%numOrderPhases] {
+			t.Errorf("version %d: phase %d, want %d", version, phase, phases[version%numOrderPhases])
+		}
+		if cycle := version.Cycle(); cycle != uint64(version)/numOrderPhases {
+			t.Errorf("version %d: cycle %d, want %d", version, cycle, uint64(version)/numOrderPhases)
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package esm
+
+import (
+	"../elevio"
+)
+
+// EventType defines the kind of an event given to Step.
+type EventType int
+
+const (
+	//NewOrder is an order added to the local queue. Uses Event.Order.
+	NewOrder EventType = iota
+	//ArrivedAtFloor is the floor sensor detecting a floor. Uses Event.Floor.
+	ArrivedAtFloor
+	//DoorTimeout is the door timer expiring.
+	DoorTimeout
+	//MotorLossTimeout is the motor loss timer expiring.
+	MotorLossTimeout
+	//ObstructionChanged is the obstruction switch changing. Uses Event.Active.
+	ObstructionChanged
+	//StopButtonChanged is the stop button being pressed or released. Uses
+	//Event.Active, and Event.AtFloor for whether the floor sensor is active.
+	StopButtonChanged
+	//RevokeOrder is a hall order taken over by another elevator. Uses
+	//Event.Order.
+	RevokeOrder
+)
+
+// Event is an input to the elevator state machine.
+type Event struct {
+	Type    EventType
+	Order   elevio.ButtonEvent
+	Floor   int
+	Active  bool
+	AtFloor bool
+}
+
+// ActionType defines the kind of an action returned by Step.
+type ActionType int
+
+const (
+	//SetMotorDirection sets the motor to Action.Motor.
+	SetMotorDirection ActionType = iota
+	//SetDoorOpenLamp sets the door open lamp to Action.Value.
+	SetDoorOpenLamp
+	//SetFloorIndicator sets the floor indicator to Action.Floor.
+	SetFloorIndicator
+	//SetStopLamp sets the stop lamp to Action.Value.
+	SetStopLamp
+	//SetButtonLamp sets the lamp of Action.Order to Action.Value.
+	SetButtonLamp
+	//StartDoorTimer (re)starts the door timer.
+	StartDoorTimer
+	//StopDoorTimer stops the door timer.
+	StopDoorTimer
+	//StartMotorLossTimer (re)starts the motor loss timer.
+	StartMotorLossTimer
+	//StopMotorLossTimer stops the motor loss timer.
+	StopMotorLossTimer
+	//ReportMotorFault reports Action.Fault, or that the motor has recovered
+	//if it is the zero value.
+	ReportMotorFault
+	//CompleteOrder reports Action.Order as completed.
+	CompleteOrder
+	//BackupQueue saves the local queue.
+	BackupQueue
+	//SendLocalData sends the elevator data to the other modules.
+	SendLocalData
+)
+
+// Action is a side effect of a transition, carried out by ESM.
+type Action struct {
+	Type  ActionType
+	Motor elevio.MotorDirection
+	Value bool
+	Floor int
+	Order elevio.ButtonEvent
+	Fault MotorFault
+}
+
+// Step returns the elevator state after event and the actions needed to get
+// there. It does not modify elev and has no side effects.
+func Step(elev ElevData, event Event) (ElevData, []Action) {
+	elev.LocalQueue = copyQueue(elev.LocalQueue)
+	var actions []Action
+
+	switch event.Type {
+	case NewOrder:
+		if !takesHallOrders(elev) && event.Order.Button != elevio.BT_Cab {
+			return elev, nil
+		}
+		elev.LocalQueue = addOrderToQueue(elev.LocalQueue, event.Order)
+		actions = append(actions, Action{Type: BackupQueue})
+		switch elev.State {
+		case Idle:
+			if hasOrderAtCurrentFloor(elev) {
+				elev, actions = openDoor(elev, actions)
+			} else {
+				elev.HeadingDir = chooseHeadingDirection(elev)
+				elev.State = Moving
+				actions = append(actions,
+					Action{Type: SetMotorDirection, Motor: getMotorDirection(elev.HeadingDir)},
+					Action{Type: StartMotorLossTimer})
+			}
+		case DoorOpen:
+			if shouldStop(elev) {
+				elev, actions = openDoor(elev, actions)
+			}
+		}
+
+	case ArrivedAtFloor:
+		elev.Floor = event.Floor
+		actions = append(actions, Action{Type: SetFloorIndicator, Floor: elev.Floor})
+		if elev.State == Emergency {
+			// Stopped, but the peers still need to know the floor.
+			return elev, append(actions, Action{Type: SendLocalData})
+		}
+		actions = append(actions, Action{Type: StartMotorLossTimer})
+
+		if elev.State == Undefined {
+			// The motor is back. Carry on in the direction it is moving.
+			elev.HeadingDir = elev.MotorFault.Direction
+			elev.State = Moving
+			elev.MotorFault = MotorFault{}
+			actions = append(actions, Action{Type: ReportMotorFault})
+		}
+		if elev.Floor == numFloors(elev)-1 {
+			elev.HeadingDir = HeadingDown
+		}
+		if elev.Floor == 0 {
+			elev.HeadingDir = HeadingUp
+		}
+		if shouldStop(elev) {
+			elev, actions = openDoor(elev, actions)
+		}
+
+	case DoorTimeout:
+		if elev.Obstructed {
+			return elev, append(actions, Action{Type: StartDoorTimer})
+		}
+		elev, actions = closeDoor(elev, actions)
+
+	case MotorLossTimeout:
+		if elev.State != Undefined {
+			// Release the hall orders so the other elevators serve them.
+			elev.State = Undefined
+			elev.MotorFault = MotorFault{Floor: elev.Floor, Direction: -elev.HeadingDir}
+			elev.LocalQueue = removeHallOrders(elev.LocalQueue)
+			actions = append(actions, Action{Type: BackupQueue})
+		}
+		elev.MotorFault.Retries++
+		elev.MotorFault.Direction = -elev.MotorFault.Direction
+		actions = append(actions,
+			Action{Type: SetMotorDirection, Motor: getMotorDirection(elev.MotorFault.Direction)},
+			Action{Type: StartMotorLossTimer},
+			Action{Type: ReportMotorFault, Fault: elev.MotorFault})
+
+	case ObstructionChanged:
+		elev.Obstructed = event.Active
+
+	case RevokeOrder:
+		order := event.Order
+		if order.Button == elevio.BT_Cab || elev.LocalQueue[order.Button][order.Floor] == 0 {
+			return elev, nil
+		}
+		// The lamp stays on, the order is not completed but served by another elevator.
+		elev.LocalQueue[order.Button][order.Floor] = 0
+		actions = append(actions, Action{Type: BackupQueue})
+
+	case StopButtonChanged:
+		if event.Active {
+			elev.State = Emergency
+			elev.LocalQueue = removeHallOrders(elev.LocalQueue)
+			actions = append(actions,
+				Action{Type: SetMotorDirection, Motor: elevio.MD_Stop},
+				Action{Type: SetStopLamp, Value: true},
+				Action{Type: StopDoorTimer},
+				Action{Type: StopMotorLossTimer},
+				Action{Type: BackupQueue})
+			if event.AtFloor {
+				actions = append(actions, Action{Type: SetDoorOpenLamp, Value: true})
+			}
+		} else if elev.State == Emergency {
+			actions = append(actions, Action{Type: SetStopLamp, Value: false})
+			if elev.MotorFault != (MotorFault{}) {
+				// Stopped while recovering from a motor loss. The motor is
+				// tried again as usual, and a loss is detected anew.
+				elev.MotorFault = MotorFault{}
+				actions = append(actions, Action{Type: ReportMotorFault})
+			}
+			if event.AtFloor {
+				// The door is open. Let it close as usual and continue from there.
+				elev.State = DoorOpen
+				actions = append(actions, Action{Type: StartDoorTimer})
+			} else {
+				// Between floors. Continue to the next floor in the heading direction.
+				elev.HeadingDir = chooseHeadingDirection(elev)
+				elev.State = Moving
+				actions = append(actions,
+					Action{Type: SetMotorDirection, Motor: getMotorDirection(elev.HeadingDir)},
+					Action{Type: StartMotorLossTimer})
+			}
+		}
+	}
+
+	return elev, append(actions, Action{Type: SendLocalData})
+}
+
+// openDoor stops at the current floor, opens the door and clears the orders
+// served by stopping.
+func openDoor(elev ElevData, actions []Action) (ElevData, []Action) {
+	if elev.State == Emergency {
+		return elev, actions
+	}
+	elev.State = DoorOpen
+	actions = append(actions,
+		Action{Type: SetMotorDirection, Motor: elevio.MD_Stop},
+		Action{Type: SetDoorOpenLamp, Value: true})
+
+	for i := 0; i < len(elev.LocalQueue); i++ {
+		order := elevio.MakeButtonEvent(i, elev.Floor)
+		if shouldClearOrder(elev, order) {
+			elev.LocalQueue[order.Button][order.Floor] = 0
+			actions = append(actions, Action{Type: CompleteOrder, Order: order})
+		}
+	}
+	elev.LocalQueue[elevio.BT_Cab][elev.Floor] = 0
+	actions = append(actions, lightsOfClearedOrders(elev)...)
+
+	return elev, append(actions,
+		Action{Type: BackupQueue},
+		Action{Type: StartDoorTimer},
+		Action{Type: StopMotorLossTimer})
+}
+
+// closeDoor closes the door and moves on to the next order, or reopens the
+// door if there is an order at the current floor in the new heading direction.
+func closeDoor(elev ElevData, actions []Action) (ElevData, []Action) {
+	if elev.State == Emergency {
+		return elev, actions
+	}
+	elev.HeadingDir = chooseHeadingDirection(elev)
+	if shouldStop(elev) && hasOrderAtCurrentFloor(elev) {
+		return openDoor(elev, actions)
+	}
+	actions = append(actions,
+		Action{Type: SetDoorOpenLamp, Value: false})
+
+	if !shouldStop(elev) {
+		elev.State = Moving
+		actions = append(actions,
+			Action{Type: SetMotorDirection, Motor: getMotorDirection(elev.HeadingDir)},
+			Action{Type: StartMotorLossTimer})
+	} else {
+		elev.State = Idle
+	}
+	return elev, actions
+}
+
+// takesHallOrders returns whether elev keeps hall orders in its queue. Faulted
+// and stopped elevators release them to the other elevators.
+func takesHallOrders(elev ElevData) bool {
+	return elev.State != Undefined && elev.State != Emergency
+}
+
+func copyQueue(queue [][]int) [][]int {
+	queueCopy := make([][]int, len(queue))
+	for i := range queue {
+		queueCopy[i] = append([]int(nil), queue[i]...)
+	}
+	return queueCopy
+}
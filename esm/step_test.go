@@ -0,0 +1,353 @@
+package esm
+
+import (
+	"reflect"
+	"testing"
+
+	"../config"
+	"../elevio"
+)
+
+const testNumFloors = 4
+
+func testElev(state ElevState, floor int, heading HeadingDirection, orders ...elevio.ButtonEvent) ElevData {
+	queue := make([][]int, config.NumButtonTypes)
+	for i := range queue {
+		queue[i] = make([]int, testNumFloors)
+	}
+	for _, order := range orders {
+		queue[order.Button][order.Floor] = 1
+	}
+	return ElevData{State: state, HeadingDir: heading, Floor: floor, LocalQueue: queue}
+}
+
+func hallUp(floor int) elevio.ButtonEvent {
+	return elevio.ButtonEvent{Floor: floor, Button: elevio.BT_HallUp}
+}
+
+func hallDown(floor int) elevio.ButtonEvent {
+	return elevio.ButtonEvent{Floor: floor, Button: elevio.BT_HallDown}
+}
+
+func cab(floor int) elevio.ButtonEvent {
+	return elevio.ButtonEvent{Floor: floor, Button: elevio.BT_Cab}
+}
+
+// openDoorActions are the actions of stopping at floor with the door opening,
+// completing completed and turning off the lamps of the orders in the heading
+// direction.
+func openDoorActions(floor int, heading HeadingDirection, completed ...elevio.ButtonEvent) []Action {
+	actions := []Action{
+		{Type: SetMotorDirection, Motor: elevio.MD_Stop},
+		{Type: SetDoorOpenLamp, Value: true},
+	}
+	for _, order := range completed {
+		actions = append(actions, Action{Type: CompleteOrder, Order: order})
+	}
+	actions = append(actions, Action{Type: SetButtonLamp, Order: cab(floor)})
+	if heading == HeadingUp {
+		actions = append(actions, Action{Type: SetButtonLamp, Order: hallUp(floor)})
+	} else {
+		actions = append(actions, Action{Type: SetButtonLamp, Order: hallDown(floor)})
+	}
+	return append(actions,
+		Action{Type: BackupQueue},
+		Action{Type: StartDoorTimer},
+		Action{Type: StopMotorLossTimer})
+}
+
+func TestStep(t *testing.T) {
+	cases := []struct {
+		name        string
+		elev        ElevData
+		event       Event
+		wantState   ElevState
+		wantHeading HeadingDirection
+		wantFault   MotorFault
+		wantQueue   []elevio.ButtonEvent
+		wantActions []Action
+	}{
+		{
+			name:        "idle to moving",
+			elev:        testElev(Idle, 0, HeadingUp),
+			event:       Event{Type: NewOrder, Order: cab(2)},
+			wantState:   Moving,
+			wantHeading: HeadingUp,
+			wantQueue:   []elevio.ButtonEvent{cab(2)},
+			wantActions: []Action{
+				{Type: BackupQueue},
+				{Type: SetMotorDirection, Motor: elevio.MD_Up},
+				{Type: StartMotorLossTimer},
+				{Type: SendLocalData},
+			},
+		},
+		{
+			name:        "idle with order at floor opens door",
+			elev:        testElev(Idle, 1, HeadingUp),
+			event:       Event{Type: NewOrder, Order: cab(1)},
+			wantState:   DoorOpen,
+			wantHeading: HeadingUp,
+			wantActions: append(append([]Action{{Type: BackupQueue}},
+				openDoorActions(1, HeadingUp, cab(1))...),
+				Action{Type: SendLocalData}),
+		},
+		{
+			name:        "door reopens for order at floor",
+			elev:        testElev(DoorOpen, 2, HeadingDown),
+			event:       Event{Type: NewOrder, Order: hallDown(2)},
+			wantState:   DoorOpen,
+			wantHeading: HeadingDown,
+			wantActions: append(append([]Action{{Type: BackupQueue}},
+				openDoorActions(2, HeadingDown, hallDown(2))...),
+				Action{Type: SendLocalData}),
+		},
+		{
+			name:        "order elsewhere waits for door",
+			elev:        testElev(DoorOpen, 2, HeadingDown),
+			event:       Event{Type: NewOrder, Order: cab(0)},
+			wantState:   DoorOpen,
+			wantHeading: HeadingDown,
+			wantQueue:   []elevio.ButtonEvent{cab(0)},
+			wantActions: []Action{{Type: BackupQueue}, {Type: SendLocalData}},
+		},
+		{
+			name:        "arriving at ordered floor stops",
+			elev:        testElev(Moving, 1, HeadingUp, hallUp(2), cab(3)),
+			event:       Event{Type: ArrivedAtFloor, Floor: 2},
+			wantState:   DoorOpen,
+			wantHeading: HeadingUp,
+			wantQueue:   []elevio.ButtonEvent{cab(3)},
+			wantActions: append(append([]Action{
+				{Type: SetFloorIndicator, Floor: 2},
+				{Type: StartMotorLossTimer}},
+				openDoorActions(2, HeadingUp, hallUp(2))...),
+				Action{Type: SendLocalData}),
+		},
+		{
+			name:        "passing floor without order",
+			elev:        testElev(Moving, 0, HeadingUp, cab(3)),
+			event:       Event{Type: ArrivedAtFloor, Floor: 1},
+			wantState:   Moving,
+			wantHeading: HeadingUp,
+			wantQueue:   []elevio.ButtonEvent{cab(3)},
+			wantActions: []Action{
+				{Type: SetFloorIndicator, Floor: 1},
+				{Type: StartMotorLossTimer},
+				{Type: SendLocalData},
+			},
+		},
+		{
+			name: "obstructed door stays open",
+			elev: func() ElevData {
+				elev := testElev(DoorOpen, 1, HeadingUp, cab(3))
+				elev.Obstructed = true
+				return elev
+			}(),
+			event:       Event{Type: DoorTimeout},
+			wantState:   DoorOpen,
+			wantHeading: HeadingUp,
+			wantQueue:   []elevio.ButtonEvent{cab(3)},
+			wantActions: []Action{{Type: StartDoorTimer}},
+		},
+		{
+			name:        "door closes and moves on",
+			elev:        testElev(DoorOpen, 1, HeadingUp, cab(3)),
+			event:       Event{Type: DoorTimeout},
+			wantState:   Moving,
+			wantHeading: HeadingUp,
+			wantQueue:   []elevio.ButtonEvent{cab(3)},
+			wantActions: []Action{
+				{Type: SetDoorOpenLamp, Value: false},
+				{Type: SetMotorDirection, Motor: elevio.MD_Up},
+				{Type: StartMotorLossTimer},
+				{Type: SendLocalData},
+			},
+		},
+		{
+			name:        "door closes to idle",
+			elev:        testElev(DoorOpen, 1, HeadingUp),
+			event:       Event{Type: DoorTimeout},
+			wantState:   Idle,
+			wantHeading: HeadingDown,
+			wantActions: []Action{
+				{Type: SetDoorOpenLamp, Value: false},
+				{Type: SendLocalData},
+			},
+		},
+		{
+			name:        "motor loss releases hall orders",
+			elev:        testElev(Moving, 1, HeadingUp, hallUp(2), hallDown(3), cab(3)),
+			event:       Event{Type: MotorLossTimeout},
+			wantState:   Undefined,
+			wantHeading: HeadingUp,
+			wantFault:   MotorFault{Floor: 1, Retries: 1, Direction: HeadingUp},
+			wantQueue:   []elevio.ButtonEvent{cab(3)},
+			wantActions: []Action{
+				{Type: BackupQueue},
+				{Type: SetMotorDirection, Motor: elevio.MD_Up},
+				{Type: StartMotorLossTimer},
+				{Type: ReportMotorFault, Fault: MotorFault{Floor: 1, Retries: 1, Direction: HeadingUp}},
+				{Type: SendLocalData},
+			},
+		},
+		{
+			name: "motor loss retries other direction",
+			elev: func() ElevData {
+				elev := testElev(Undefined, 1, HeadingUp, cab(3))
+				elev.MotorFault = MotorFault{Floor: 1, Retries: 1, Direction: HeadingUp}
+				return elev
+			}(),
+			event:       Event{Type: MotorLossTimeout},
+			wantState:   Undefined,
+			wantHeading: HeadingUp,
+			wantFault:   MotorFault{Floor: 1, Retries: 2, Direction: HeadingDown},
+			wantQueue:   []elevio.ButtonEvent{cab(3)},
+			wantActions: []Action{
+				{Type: SetMotorDirection, Motor: elevio.MD_Down},
+				{Type: StartMotorLossTimer},
+				{Type: ReportMotorFault, Fault: MotorFault{Floor: 1, Retries: 2, Direction: HeadingDown}},
+				{Type: SendLocalData},
+			},
+		},
+		{
+			name: "motor recovers at floor",
+			elev: func() ElevData {
+				elev := testElev(Undefined, 1, HeadingUp, cab(0))
+				elev.MotorFault = MotorFault{Floor: 1, Retries: 2, Direction: HeadingDown}
+				return elev
+			}(),
+			event:       Event{Type: ArrivedAtFloor, Floor: 0},
+			wantState:   DoorOpen,
+			wantHeading: HeadingUp,
+			wantActions: append(append([]Action{
+				{Type: SetFloorIndicator, Floor: 0},
+				{Type: StartMotorLossTimer},
+				{Type: ReportMotorFault}},
+				openDoorActions(0, HeadingUp, cab(0))...),
+				Action{Type: SendLocalData}),
+		},
+		{
+			name:        "hall order ignored without motor",
+			elev:        testElev(Undefined, 1, HeadingUp),
+			event:       Event{Type: NewOrder, Order: hallUp(2)},
+			wantState:   Undefined,
+			wantHeading: HeadingUp,
+		},
+		{
+			name:        "stop button between floors",
+			elev:        testElev(Moving, 1, HeadingUp, hallUp(2), cab(3)),
+			event:       Event{Type: StopButtonChanged, Active: true},
+			wantState:   Emergency,
+			wantHeading: HeadingUp,
+			wantQueue:   []elevio.ButtonEvent{cab(3)},
+			wantActions: []Action{
+				{Type: SetMotorDirection, Motor: elevio.MD_Stop},
+				{Type: SetStopLamp, Value: true},
+				{Type: StopDoorTimer},
+				{Type: StopMotorLossTimer},
+				{Type: BackupQueue},
+				{Type: SendLocalData},
+			},
+		},
+		{
+			name:        "stop released between floors",
+			elev:        testElev(Emergency, 1, HeadingUp, cab(3)),
+			event:       Event{Type: StopButtonChanged, Active: false},
+			wantState:   Moving,
+			wantHeading: HeadingUp,
+			wantQueue:   []elevio.ButtonEvent{cab(3)},
+			wantActions: []Action{
+				{Type: SetStopLamp, Value: false},
+				{Type: SetMotorDirection, Motor: elevio.MD_Up},
+				{Type: StartMotorLossTimer},
+				{Type: SendLocalData},
+			},
+		},
+		{
+			name: "stop released after motor loss",
+			elev: func() ElevData {
+				elev := testElev(Emergency, 1, HeadingUp, cab(3))
+				elev.MotorFault = MotorFault{Floor: 1, Retries: 2, Direction: HeadingDown}
+				return elev
+			}(),
+			event:       Event{Type: StopButtonChanged, Active: false},
+			wantState:   Moving,
+			wantHeading: HeadingUp,
+			wantQueue:   []elevio.ButtonEvent{cab(3)},
+			wantActions: []Action{
+				{Type: SetStopLamp, Value: false},
+				{Type: ReportMotorFault},
+				{Type: SetMotorDirection, Motor: elevio.MD_Up},
+				{Type: StartMotorLossTimer},
+				{Type: SendLocalData},
+			},
+		},
+		{
+			name:        "stop released at floor",
+			elev:        testElev(Emergency, 1, HeadingUp),
+			event:       Event{Type: StopButtonChanged, Active: false, AtFloor: true},
+			wantState:   DoorOpen,
+			wantHeading: HeadingUp,
+			wantActions: []Action{
+				{Type: SetStopLamp, Value: false},
+				{Type: StartDoorTimer},
+				{Type: SendLocalData},
+			},
+		},
+		{
+			name:        "arriving at floor in emergency",
+			elev:        testElev(Emergency, 1, HeadingUp, cab(2)),
+			event:       Event{Type: ArrivedAtFloor, Floor: 2},
+			wantState:   Emergency,
+			wantHeading: HeadingUp,
+			wantQueue:   []elevio.ButtonEvent{cab(2)},
+			wantActions: []Action{
+				{Type: SetFloorIndicator, Floor: 2},
+				{Type: SendLocalData},
+			},
+		},
+		{
+			name:        "revoked order removed",
+			elev:        testElev(Moving, 0, HeadingUp, hallUp(2), cab(3)),
+			event:       Event{Type: RevokeOrder, Order: hallUp(2)},
+			wantState:   Moving,
+			wantHeading: HeadingUp,
+			wantQueue:   []elevio.ButtonEvent{cab(3)},
+			wantActions: []Action{{Type: BackupQueue}, {Type: SendLocalData}},
+		},
+		{
+			name:        "revoked cab order kept",
+			elev:        testElev(Moving, 0, HeadingUp, cab(3)),
+			event:       Event{Type: RevokeOrder, Order: cab(3)},
+			wantState:   Moving,
+			wantHeading: HeadingUp,
+			wantQueue:   []elevio.ButtonEvent{cab(3)},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			queueBefore := copyQueue(c.elev.LocalQueue)
+			elev, actions := Step(c.elev, c.event)
+
+			if !reflect.DeepEqual(c.elev.LocalQueue, queueBefore) {
+				t.Errorf("Step modified the queue given to it: %v, was %v", c.elev.LocalQueue, queueBefore)
+			}
+			if elev.State != c.wantState {
+				t.Errorf("state %v, want %v", elev.State, c.wantState)
+			}
+			if elev.HeadingDir != c.wantHeading {
+				t.Errorf("heading %d, want %d", elev.HeadingDir, c.wantHeading)
+			}
+			if elev.MotorFault != c.wantFault {
+				t.Errorf("motor fault %+v, want %+v", elev.MotorFault, c.wantFault)
+			}
+			if want := testElev(0, 0, 0, c.wantQueue...).LocalQueue; !reflect.DeepEqual(elev.LocalQueue, want) {
+				t.Errorf("queue %v, want %v", elev.LocalQueue, want)
+			}
+			if !reflect.DeepEqual(actions, c.wantActions) {
+				t.Errorf("actions\n  %+v\nwant\n  %+v", actions, c.wantActions)
+			}
+		})
+	}
+}
@@ -3,18 +3,21 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"os/signal"
 	"time"
 
 	"./network/bcast"
 	"./network/peers"
+	"./network/reliable"
 
 	"./config"
 	dist "./distribution"
 	sync "./synchronization"
 
 	"./elevio"
+	"./elevio/simcmd"
 	"./esm"
 	"./network/localip"
 )
@@ -22,10 +25,23 @@ import (
 func main() {
 
 	var myID string
-	var simPort int
+	var sim string
+	var simScript string
+	var configPath string
+	flagCfg := config.Default()
 	flag.StringVar(&myID, "myID", "", "myID of this peer")
-	flag.IntVar(&simPort, "simPort", 15657, "Simulator connection port")
+	flag.StringVar(&sim, "sim", "tcp", "Elevator backend: tcp (elevator server on simPort) or internal (in-process simulator)")
+	flag.StringVar(&simScript, "simScript", "", "With -sim=internal, read simulator commands from this file instead of stdin")
+	flag.StringVar(&configPath, "config", "", "Path to a JSON configuration file. Flags override its settings")
+	flagCfg.AddFlags(flag.CommandLine)
 	flag.Parse()
+
+	cfg, err := config.Load(configPath, flag.CommandLine)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
 	if myID == "" {
 		localIP, err := localip.LocalIP()
 		if err != nil {
@@ -40,16 +56,22 @@ func main() {
 	buttonPressed := make(chan elevio.ButtonEvent)
 	// hardware -> esm
 	arrivedAtFloor := make(chan int)
+	obstruction := make(chan bool)
+	stopButton := make(chan bool)
 
 	// distribution -> esm
 	turnOnLight := make(chan elevio.ButtonEvent)
 	turnOffLight := make(chan elevio.ButtonEvent)
 	newOrder := make(chan elevio.ButtonEvent)
-	watchDogTimeOut := make(chan bool)
+	revokedOrder := make(chan elevio.ButtonEvent)
 
 	// distribution -> synchronization
 	hallOrder := make(chan elevio.ButtonEvent)
 
+	// distribution <-> network
+	outgoingAssignment := make(chan dist.AssignmentTable)
+	incomingAssignment := make(chan dist.AssignmentTable)
+
 	// esm -> synchronization
 	localElevData := make(chan esm.ElevData)
 	completedOrder := make(chan elevio.ButtonEvent)
@@ -60,31 +82,39 @@ func main() {
 
 	// synchronization -> network
 	outgoingMsg := make(chan esm.ElevData)
+	outgoingCabRecovery := make(chan sync.CabOrderRecovery)
+	outgoingOrderEvent := make(chan sync.OrderEvent)
 	transmitEnable := make(chan bool)
 
 	// network to synchronization
 	incomingMsg := make(chan esm.ElevData)
+	incomingCabRecovery := make(chan sync.CabOrderRecovery)
+	incomingOrderEvent := make(chan sync.OrderEvent)
 	peerUpdateCh := make(chan peers.PeerUpdate)
 
 	esmChannels := esm.Channels{
-		NewOrder:        newOrder,
-		CompletedOrder:  completedOrder,
-		ArrivedAtFloor:  arrivedAtFloor,
-		WatchDogTimeOut: watchDogTimeOut,
-		TurnOnLight:     turnOnLight,
-		TurnOffLight:    turnOffLight,
-		LocalElevData:   localElevData,
+		NewOrder:       newOrder,
+		RevokedOrder:   revokedOrder,
+		CompletedOrder: completedOrder,
+		ArrivedAtFloor: arrivedAtFloor,
+		Obstruction:    obstruction,
+		StopButton:     stopButton,
+		TurnOnLight:    turnOnLight,
+		TurnOffLight:   turnOffLight,
+		LocalElevData:  localElevData,
 	}
 
 	distributionChannels := dist.Channels{
 		ButtonPressed:           buttonPressed,
 		NewOrder:                newOrder,
+		RevokeOrder:             revokedOrder,
 		SyncedElevData:          syncedElevData,
-		WatchDogTimeOut:         watchDogTimeOut,
 		TurnOnLight:             turnOnLight,
 		TurnOffLight:            turnOffLight,
 		ClearedOrderStatusOrder: clearedOrderStatusOrder,
 		HallOrder:               hallOrder,
+		OutgoingAssignment:      outgoingAssignment,
+		IncomingAssignment:      incomingAssignment,
 	}
 
 	syncChannels := sync.Channels{
@@ -97,49 +127,97 @@ func main() {
 		CompletedOrder:          completedOrder,
 		HallOrder:               hallOrder,
 		ClearedOrderStatusOrder: clearedOrderStatusOrder,
+		OutgoingCabRecovery:     outgoingCabRecovery,
+		IncomingCabRecovery:     incomingCabRecovery,
+		RecoveredCabOrder:       buttonPressed,
+		OutgoingOrderEvent:      outgoingOrderEvent,
+		IncomingOrderEvent:      incomingOrderEvent,
 	}
 
 	// Connect to server
-	connectionPort := fmt.Sprintf("localhost:%d", simPort)
+	var driver elevio.Driver
+	switch sim {
+	case "tcp":
+		connectionPort := fmt.Sprintf("localhost:%d", cfg.SimPort)
+		driver = elevio.NewTCPDriver(connectionPort, cfg.NumFloors)
+	case "internal":
+		car := elevio.NewSimDriver(cfg.NumFloors, cfg.TravelTimeDuration)
+		var input io.Reader = os.Stdin
+		if simScript != "" {
+			file, err := os.Open(simScript)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			defer file.Close()
+			input = file
+		}
+		go simcmd.ReadCommands(input, car)
+		driver = car
+	default:
+		fmt.Println("Unknown elevator backend: ", sim)
+		os.Exit(1)
+	}
+
+	backup, err := esm.OpenBackup(cfg.StateDir, myID)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Println("Backing up orders to ", backup.Path())
+
+	strategy, err := dist.NewStrategy(cfg.DispatchStrategy, cfg)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 
 	// Initiate elevator
-	initFloor := elevio.Init(connectionPort, config.NumFloors)
+	initFloor := elevio.Init(driver)
 
 	// Start network communication
-	go bcast.Receiver(20017, incomingMsg)
-	go bcast.Transmitter(20017, outgoingMsg)
-	go peers.Receiver(20018, peerUpdateCh)
-	go peers.Transmitter(20018, myID, transmitEnable)
+	go bcast.Receiver(cfg.BcastPort, incomingMsg, incomingCabRecovery, incomingAssignment)
+	go bcast.Transmitter(cfg.BcastPort, outgoingMsg, outgoingCabRecovery, outgoingAssignment)
+	go peers.Receiver(cfg.PeersPort, peerUpdateCh)
+	go peers.Transmitter(cfg.PeersPort, myID, transmitEnable)
+	if cfg.ReliableEvents {
+		reliablePeerUpdateCh := make(chan peers.PeerUpdate)
+		go peers.Receiver(cfg.PeersPort, reliablePeerUpdateCh)
+		go reliable.Link(cfg.ReliablePort, myID, cfg.RetransmitTimerDuration, reliablePeerUpdateCh,
+			[]interface{}{outgoingOrderEvent}, []interface{}{incomingOrderEvent})
+	}
 
 	// Start elevator polling
-	go elevio.PollButtons(buttonPressed)
-	go elevio.PollFloorSensor(arrivedAtFloor)
-	go killSwitch()
+	go elevio.PollButtons(driver, buttonPressed)
+	go elevio.PollFloorSensor(driver, arrivedAtFloor)
+	go elevio.PollObstructionSwitch(driver, obstruction)
+	go elevio.PollStopButton(driver, stopButton)
+	go killSwitch(driver)
 
 	// Module
-	go dist.Distribute(distributionChannels, myID)
-	go esm.ESM(esmChannels, initFloor)
-	go sync.Synchronize(syncChannels, myID)
+	go dist.Distribute(distributionChannels, driver, cfg, strategy, myID)
+	go esm.ESM(esmChannels, driver, cfg, backup, initFloor)
+	go sync.Synchronize(syncChannels, cfg, myID)
 
 	select {}
 }
 
-func killSwitch() {
+func killSwitch(driver elevio.Driver) {
 	// killSwitch turns the motor off if the program is killed with CTRL+C.
-	c := make(chan os.Signal)
+	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt)
 	<-c
-	elevio.SetMotorDirection(elevio.MD_Stop)
+	driver.SetMotorDirection(elevio.MD_Stop)
 	fmt.Println("\x1b[31;1m", "User terminated program.", "\x1b[0m")
 	for i := 0; i < 10; i++ {
-		elevio.SetMotorDirection(elevio.MD_Stop)
+		driver.SetMotorDirection(elevio.MD_Stop)
 		if i%2 == 0 {
-			elevio.SetStopLamp(true)
+			driver.SetStopLamp(true)
 		} else {
-			elevio.SetStopLamp(false)
+			driver.SetStopLamp(false)
 		}
 		time.Sleep(200 * time.Millisecond)
 	}
-	elevio.SetMotorDirection(elevio.MD_Stop)
+	driver.SetMotorDirection(elevio.MD_Stop)
 	os.Exit(1)
 }
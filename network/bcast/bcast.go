@@ -2,15 +2,18 @@ package bcast
 
 import (
 	"../conn"
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"net"
+	"os"
 	"reflect"
-	"strings"
+	"time"
 )
 
 // Encodes received values from `chans` into type-tagged JSON, then broadcasts
-// it on `port`
+// it on `port`. Messages larger than one datagram are sent in fragments, and
+// messages that can not be sent are logged.
 func Transmitter(port int, chans ...interface{}) {
 	checkArgs(chans...)
 
@@ -31,28 +34,83 @@ func Transmitter(port int, chans ...interface{}) {
 
 	conn := conn.DialBroadcastUDP(port)
 	addr, _ := net.ResolveUDPAddr("udp4", fmt.Sprintf("255.255.255.255:%d", port))
+
+	// Fragments are identified by this transmitter and a message count.
+	transmitterID := fmt.Sprintf("%x-%x", time.Now().UnixNano(), os.Getpid())
+	msgCount := 0
+	lastWriteErr := ""
 	for {
 		chosen, value, _ := reflect.Select(selectCases)
-		buf, _ := json.Marshal(value.Interface())
-		conn.WriteTo([]byte(typeNames[chosen]+string(buf)), addr)
+		buf, err := json.Marshal(value.Interface())
+		if err != nil {
+			fmt.Printf("bcast: can not encode %s: %v\n", typeNames[chosen], err)
+			continue
+		}
+		msgCount++
+		datagrams, err := split(append([]byte(typeNames[chosen]), buf...), fmt.Sprintf("%s-%d", transmitterID, msgCount))
+		if err != nil {
+			fmt.Printf("bcast: not sending %s: %v\n", typeNames[chosen], err)
+			continue
+		}
+		for _, datagram := range datagrams {
+			_, err = conn.WriteTo(datagram, addr)
+			if err != nil {
+				break
+			}
+		}
+		// Only changes are logged, as the network may be down for a while.
+		if err != nil && err.Error() != lastWriteErr {
+			fmt.Printf("bcast: sending %s failed: %v\n", typeNames[chosen], err)
+			lastWriteErr = err.Error()
+		} else if err == nil && lastWriteErr != "" {
+			fmt.Println("bcast: sending again")
+			lastWriteErr = ""
+		}
 	}
 }
 
 // Matches type-tagged JSON received on `port` to element types of `chans`, then
-// sends the decoded value on the corresponding channel
+// sends the decoded value on the corresponding channel. Fragmented messages
+// are reassembled, and messages that can not be delivered are logged.
 func Receiver(port int, chans ...interface{}) {
 	checkArgs(chans...)
 
-	var buf [1024]byte
+	// One byte more than a datagram may be, to tell truncated ones.
+	var buf [maxDatagramSize + 1]byte
+	partials := make(map[string]*partialMessage)
 	conn := conn.DialBroadcastUDP(port)
 	for {
-		n, _, _ := conn.ReadFrom(buf[0:])
+		conn.SetReadDeadline(time.Now().Add(reassemblyTimeout))
+		n, from, err := conn.ReadFrom(buf[0:])
+		if err := dropExpired(partials, time.Now()); err != nil {
+			fmt.Printf("bcast: %v\n", err)
+		}
+		if err != nil {
+			continue
+		}
+		if n > maxDatagramSize {
+			fmt.Printf("bcast: dropping datagram from %v larger than %d bytes\n", from, maxDatagramSize)
+			continue
+		}
+		msg := buf[0:n]
+		if bytes.HasPrefix(msg, fragmentTag) {
+			msg, err = reassemble(partials, msg, time.Now())
+			if err != nil {
+				fmt.Printf("bcast: dropping fragment from %v: %v\n", from, err)
+			}
+			if msg == nil {
+				continue
+			}
+		}
 		for _, ch := range chans {
 			T := reflect.TypeOf(ch).Elem()
 			typeName := T.String()
-			if strings.HasPrefix(string(buf[0:n])+"{", typeName) {
+			if bytes.HasPrefix(msg, []byte(typeName)) {
 				v := reflect.New(T)
-				json.Unmarshal(buf[len(typeName):n], v.Interface())
+				if err := json.Unmarshal(msg[len(typeName):], v.Interface()); err != nil {
+					fmt.Printf("bcast: can not decode %s from %v: %v\n", typeName, from, err)
+					continue
+				}
 
 				reflect.Select([]reflect.SelectCase{{
 					Dir:  reflect.SelectSend,
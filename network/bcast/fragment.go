@@ -0,0 +1,108 @@
+package bcast
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+)
+
+// maxDatagramSize is the largest datagram sent or received. Larger messages
+// are split into fragments.
+const maxDatagramSize = 1024
+
+// maxFragments is the most fragments a message is split into.
+const maxFragments = 64
+
+// MaxMessageSize is the largest message, type name included, that can be sent.
+const MaxMessageSize = maxFragments * (maxDatagramSize - maxHeaderSize)
+
+// maxHeaderSize is the room kept for the header in each fragment.
+const maxHeaderSize = 64
+
+// reassemblyTimeout is how long the fragments of a message are waited for.
+const reassemblyTimeout = 500 * time.Millisecond
+
+// fragmentTag starts every fragment. It can not start a type name.
+var fragmentTag = []byte("#fragment ")
+
+// partialMessage is a message being reassembled.
+type partialMessage struct {
+	fragments [][]byte
+	received  int
+	started   time.Time
+}
+
+// split returns the datagrams to send msg in, fragmenting it if it is too
+// large for one. id must be unique for each message.
+func split(msg []byte, id string) ([][]byte, error) {
+	if len(msg) <= maxDatagramSize && !bytes.HasPrefix(msg, fragmentTag) {
+		return [][]byte{msg}, nil
+	}
+	if len(msg) > MaxMessageSize {
+		return nil, fmt.Errorf("message of %d bytes is larger than %d bytes", len(msg), MaxMessageSize)
+	}
+	chunkSize := maxDatagramSize - maxHeaderSize
+	count := (len(msg) + chunkSize - 1) / chunkSize
+	datagrams := make([][]byte, 0, count)
+	for i := 0; i < count; i++ {
+		end := (i + 1) * chunkSize
+		if end > len(msg) {
+			end = len(msg)
+		}
+		header := fmt.Sprintf("%s%s %d %d\n", fragmentTag, id, i, count)
+		datagrams = append(datagrams, append([]byte(header), msg[i*chunkSize:end]...))
+	}
+	return datagrams, nil
+}
+
+// reassemble adds the fragment in datagram to partials, and returns the whole
+// message once all its fragments are received.
+func reassemble(partials map[string]*partialMessage, datagram []byte, now time.Time) ([]byte, error) {
+	end := bytes.IndexByte(datagram, '\n')
+	if end < 0 {
+		return nil, fmt.Errorf("fragment without header end")
+	}
+	var id string
+	var index, count int
+	if _, err := fmt.Sscanf(string(datagram[len(fragmentTag):end]), "%s %d %d", &id, &index, &count); err != nil {
+		return nil, fmt.Errorf("malformed fragment header: %v", err)
+	}
+	if count < 1 || count > maxFragments || index < 0 || index >= count {
+		return nil, fmt.Errorf("fragment %d of %d out of range", index, count)
+	}
+
+	partial, found := partials[id]
+	if !found {
+		partial = &partialMessage{fragments: make([][]byte, count), started: now}
+		partials[id] = partial
+	}
+	if len(partial.fragments) != count {
+		delete(partials, id)
+		return nil, fmt.Errorf("fragments of message %s disagree on the count", id)
+	}
+	if partial.fragments[index] == nil {
+		partial.fragments[index] = append([]byte(nil), datagram[end+1:]...)
+		partial.received++
+	}
+	if partial.received < count {
+		return nil, nil
+	}
+	delete(partials, id)
+	return bytes.Join(partial.fragments, nil), nil
+}
+
+// dropExpired removes the messages whose fragments have not all arrived
+// within reassemblyTimeout, and returns an error describing them.
+func dropExpired(partials map[string]*partialMessage, now time.Time) error {
+	var dropped []string
+	for id, partial := range partials {
+		if now.Sub(partial.started) > reassemblyTimeout {
+			dropped = append(dropped, fmt.Sprintf("%s (%d of %d fragments)", id, partial.received, len(partial.fragments)))
+			delete(partials, id)
+		}
+	}
+	if len(dropped) == 0 {
+		return nil
+	}
+	return fmt.Errorf("incomplete messages dropped: %v", dropped)
+}
@@ -0,0 +1,162 @@
+package bcast
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testMessage returns a message of n bytes that differs at every position
+// within a fragment, so fragments put back in the wrong order are noticed.
+func testMessage(n int) []byte {
+	msg := make([]byte, n)
+	for i := range msg {
+		msg[i] = byte('a' + i%23)
+	}
+	return msg
+}
+
+// reassembleAll passes datagrams to reassemble in order, and returns the
+// messages completed.
+func reassembleAll(t *testing.T, partials map[string]*partialMessage, datagrams [][]byte, now time.Time) [][]byte {
+	var msgs [][]byte
+	for _, datagram := range datagrams {
+		if !bytes.HasPrefix(datagram, fragmentTag) {
+			msgs = append(msgs, datagram)
+			continue
+		}
+		msg, err := reassemble(partials, datagram, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg != nil {
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs
+}
+
+func TestFragmentRoundTrip(t *testing.T) {
+	now := time.Unix(1000, 0)
+	for _, size := range []int{1, maxDatagramSize, maxDatagramSize + 1, 5 * maxDatagramSize, MaxMessageSize} {
+		msg := testMessage(size)
+		datagrams, err := split(msg, "a-1")
+		if err != nil {
+			t.Fatalf("%d bytes: %v", size, err)
+		}
+		if size <= maxDatagramSize && len(datagrams) != 1 {
+			t.Errorf("%d bytes split into %d datagrams, want 1", size, len(datagrams))
+		}
+		for _, datagram := range datagrams {
+			if len(datagram) > maxDatagramSize {
+				t.Errorf("%d bytes: datagram of %d bytes", size, len(datagram))
+			}
+		}
+
+		partials := make(map[string]*partialMessage)
+		msgs := reassembleAll(t, partials, datagrams, now)
+		if len(msgs) != 1 || !bytes.Equal(msgs[0], msg) {
+			t.Errorf("%d bytes: reassembled %d messages, want the message", size, len(msgs))
+		}
+		if len(partials) != 0 {
+			t.Errorf("%d bytes: %d partial messages left", size, len(partials))
+		}
+	}
+}
+
+// A small message that looks like a fragment is sent as one.
+func TestFragmentTaggedMessage(t *testing.T) {
+	msg := append(append([]byte(nil), fragmentTag...), "x 0 1\nbody"...)
+	datagrams, err := split(msg, "a-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs := reassembleAll(t, make(map[string]*partialMessage), datagrams, time.Unix(1000, 0))
+	if len(msgs) != 1 || !bytes.Equal(msgs[0], msg) {
+		t.Errorf("reassembled %q, want %q", msgs, msg)
+	}
+}
+
+func TestFragmentsOutOfOrderAndDuplicated(t *testing.T) {
+	msg := testMessage(4 * maxDatagramSize)
+	datagrams, err := split(msg, "a-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := len(datagrams)
+	var shuffled [][]byte
+	for i := n - 1; i >= 0; i-- {
+		shuffled = append(shuffled, datagrams[i])
+		if i == n-2 {
+			shuffled = append(shuffled, datagrams[n-1], datagrams[i])
+		}
+	}
+
+	partials := make(map[string]*partialMessage)
+	msgs := reassembleAll(t, partials, shuffled, time.Unix(1000, 0))
+	if len(msgs) != 1 || !bytes.Equal(msgs[0], msg) {
+		t.Fatalf("reassembled %d messages, want the message once", len(msgs))
+	}
+
+	// A late copy of a fragment starts a message that is never completed.
+	if msgs := reassembleAll(t, partials, datagrams[:1], time.Unix(1000, 0)); len(msgs) != 0 {
+		t.Errorf("late duplicate completed %d messages", len(msgs))
+	}
+}
+
+func TestFragmentCountMismatch(t *testing.T) {
+	now := time.Unix(1000, 0)
+	partials := make(map[string]*partialMessage)
+	if _, err := reassemble(partials, []byte("#fragment a-1 0 3\nabc"), now); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reassemble(partials, []byte("#fragment a-1 1 2\nabc"), now); err == nil {
+		t.Errorf("fragment counts 3 and 2 accepted")
+	}
+	if len(partials) != 0 {
+		t.Errorf("message with disagreeing counts kept")
+	}
+
+	for _, header := range []string{
+		"#fragment a-1 3 3\n",
+		"#fragment a-1 -1 3\n",
+		"#fragment a-1 0 0\n",
+		"#fragment a-1 0 65\n",
+		"#fragment a-1 zero 3\n",
+		"#fragment a-1 0 3",
+	} {
+		if _, err := reassemble(partials, []byte(header+"abc"), now); err == nil {
+			t.Errorf("fragment %q accepted", header)
+		}
+	}
+}
+
+func TestDropExpired(t *testing.T) {
+	start := time.Unix(1000, 0)
+	partials := make(map[string]*partialMessage)
+	reassembleAll(t, partials, [][]byte{[]byte("#fragment a-1 0 2\nabc")}, start)
+	reassembleAll(t, partials, [][]byte{[]byte("#fragment a-2 0 2\nabc")}, start.Add(reassemblyTimeout/2))
+
+	if err := dropExpired(partials, start.Add(reassemblyTimeout)); err != nil || len(partials) != 2 {
+		t.Errorf("dropped at the timeout: %v, %d messages left, want 2", err, len(partials))
+	}
+	err := dropExpired(partials, start.Add(reassemblyTimeout+time.Millisecond))
+	if err == nil || !strings.Contains(err.Error(), "a-1 (1 of 2 fragments)") {
+		t.Errorf("dropped after the timeout: %v, want a-1", err)
+	}
+	if _, found := partials["a-2"]; !found || len(partials) != 1 {
+		t.Errorf("%d messages left, want a-2", len(partials))
+	}
+
+	// The fragment completing a dropped message starts it over.
+	if msgs := reassembleAll(t, partials, [][]byte{[]byte("#fragment a-1 1 2\ndef")}, start.Add(time.Second)); len(msgs) != 0 {
+		t.Errorf("message completed after being dropped")
+	}
+}
+
+func TestSplitRejectsLargeMessages(t *testing.T) {
+	if _, err := split(testMessage(MaxMessageSize+1), "a-1"); err == nil {
+		t.Errorf("message of %d bytes split, larger than %d", MaxMessageSize+1, MaxMessageSize)
+	}
+}
@@ -0,0 +1,236 @@
+package reliable
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	"../bcast"
+	"../peers"
+)
+
+// maxRetransmissions is how many times a message is sent again before it is
+// given up on.
+const maxRetransmissions = 100
+
+// receiveWindow is how many sequence numbers after the lowest one missing
+// from a peer are kept track of. A sequence number missing for longer is given
+// up on, as the sender has stopped retransmitting it or never sent it to us.
+const receiveWindow = 1024
+
+// Packet is a message or an acknowledgement, broadcast with bcast.
+type Packet struct {
+	From string
+	// Epoch identifies the run of the sender, so a restarted sender counting
+	// sequence numbers from 1 again is not taken for duplicates.
+	Epoch int64
+	Seq   uint64
+	// Ack is set on acknowledgements. To is the sender of the message
+	// acknowledged, and Epoch and Seq are copied from the message.
+	Ack  bool
+	To   string
+	Type string
+	Data json.RawMessage
+}
+
+// pending is a sent message waiting for acknowledgements.
+type pending struct {
+	packet   Packet
+	awaiting map[string]bool
+	attempts int
+}
+
+// received holds the sequence numbers received from one run of a peer.
+type received struct {
+	epoch int64
+	// next is the lowest sequence number not yet received, seqs holds the
+	// higher ones received, at most receiveWindow of them.
+	next uint64
+	seqs map[uint64]bool
+}
+
+// Link sends the values received on the channels in outgoing to every peer on
+// port, and retransmits them every resendInterval until each peer online when
+// they were sent has acknowledged them or is lost. Values sent by peers are
+// passed on once on the channel in incoming of the same element type, in the
+// order they arrive, which need not be the order they were sent in. Peers are
+// learned from peerUpdateCh.
+//
+// Like with bcast, all channels must have mutually different element types.
+func Link(port int, myID string, resendInterval time.Duration, peerUpdateCh <-chan peers.PeerUpdate, outgoing []interface{}, incoming []interface{}) {
+	checkArgs(outgoing, incoming)
+
+	txPacket := make(chan Packet)
+	rxPacket := make(chan Packet)
+	go bcast.Transmitter(port, txPacket)
+	go bcast.Receiver(port, rxPacket)
+
+	incomingByType := make(map[string]interface{})
+	for _, ch := range incoming {
+		incomingByType[reflect.TypeOf(ch).Elem().String()] = ch
+	}
+
+	const (
+		peerCase = iota
+		packetCase
+		resendCase
+		numFixedCases
+	)
+	resendTicker := time.NewTicker(resendInterval)
+	selectCases := make([]reflect.SelectCase, numFixedCases+len(outgoing))
+	selectCases[peerCase] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(peerUpdateCh)}
+	selectCases[packetCase] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(rxPacket)}
+	selectCases[resendCase] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(resendTicker.C)}
+	for i, ch := range outgoing {
+		selectCases[numFixedCases+i] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ch)}
+	}
+
+	epoch := time.Now().UnixNano()
+	seq := uint64(0)
+	online := make(map[string]bool)
+	unacked := make(map[uint64]*pending)
+	receivedFrom := make(map[string]*received)
+
+	for {
+		chosen, value, _ := reflect.Select(selectCases)
+		switch chosen {
+		case peerCase:
+			update := value.Interface().(peers.PeerUpdate)
+			online = make(map[string]bool)
+			for _, id := range update.Peers {
+				if id != myID {
+					online[id] = true
+				}
+			}
+			for _, id := range update.Lost {
+				for s, msg := range unacked {
+					delete(msg.awaiting, id)
+					if len(msg.awaiting) == 0 {
+						delete(unacked, s)
+					}
+				}
+			}
+
+		case packetCase:
+			packet := value.Interface().(Packet)
+			if packet.From == myID {
+				break
+			}
+			if packet.Ack {
+				if msg, found := unacked[packet.Seq]; found && packet.To == myID && packet.Epoch == epoch {
+					delete(msg.awaiting, packet.From)
+					if len(msg.awaiting) == 0 {
+						delete(unacked, packet.Seq)
+					}
+				}
+				break
+			}
+			// Acknowledge every copy, as the acknowledgement of an earlier
+			// one may have been lost.
+			txPacket <- Packet{From: myID, Epoch: packet.Epoch, Seq: packet.Seq, Ack: true, To: packet.From}
+			if !isNew(receivedFrom, packet) {
+				break
+			}
+			ch, found := incomingByType[packet.Type]
+			if !found {
+				fmt.Printf("Reliable link: no channel for %s from %s\n", packet.Type, packet.From)
+				break
+			}
+			T := reflect.TypeOf(ch).Elem()
+			v := reflect.New(T)
+			if err := json.Unmarshal(packet.Data, v.Interface()); err != nil {
+				fmt.Printf("Reliable link: dropping %s from %s: %v\n", packet.Type, packet.From, err)
+				break
+			}
+			reflect.ValueOf(ch).Send(reflect.Indirect(v))
+
+		case resendCase:
+			for s, msg := range unacked {
+				if msg.attempts >= maxRetransmissions {
+					fmt.Printf("Reliable link: giving up on %s %d, not acknowledged by %v\n",
+						msg.packet.Type, s, keys(msg.awaiting))
+					delete(unacked, s)
+					continue
+				}
+				msg.attempts++
+				txPacket <- msg.packet
+			}
+
+		default:
+			data, err := json.Marshal(value.Interface())
+			if err != nil {
+				fmt.Printf("Reliable link: can not send %v: %v\n", value.Type(), err)
+				break
+			}
+			seq++
+			packet := Packet{From: myID, Epoch: epoch, Seq: seq, Type: value.Type().String(), Data: data}
+			if len(online) > 0 {
+				awaiting := make(map[string]bool)
+				for id := range online {
+					awaiting[id] = true
+				}
+				unacked[seq] = &pending{packet: packet, awaiting: awaiting}
+			}
+			txPacket <- packet
+		}
+	}
+}
+
+// isNew records packet as received, and returns whether it was not received
+// before. Packets more than receiveWindow below the highest sequence number
+// received are taken as received before.
+func isNew(receivedFrom map[string]*received, packet Packet) bool {
+	r, found := receivedFrom[packet.From]
+	if !found || r.epoch != packet.Epoch {
+		r = &received{epoch: packet.Epoch, next: 1, seqs: make(map[uint64]bool)}
+		receivedFrom[packet.From] = r
+	}
+	if packet.Seq < r.next || r.seqs[packet.Seq] {
+		return false
+	}
+	r.seqs[packet.Seq] = true
+	if packet.Seq >= r.next+receiveWindow {
+		r.next = packet.Seq - receiveWindow + 1
+		for s := range r.seqs {
+			if s < r.next {
+				delete(r.seqs, s)
+			}
+		}
+	}
+	for r.seqs[r.next] {
+		delete(r.seqs, r.next)
+		r.next++
+	}
+	return true
+}
+
+func keys(set map[string]bool) []string {
+	var ids []string
+	for id := range set {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// checkArgs checks that all arguments are channels of mutually different
+// element types.
+func checkArgs(outgoing []interface{}, incoming []interface{}) {
+	for _, chans := range [][]interface{}{outgoing, incoming} {
+		seen := make(map[reflect.Type]bool)
+		for i, ch := range chans {
+			if reflect.ValueOf(ch).Kind() != reflect.Chan {
+				panic(fmt.Sprintf("Argument must be a channel, got '%s' instead (arg#%d)",
+					reflect.TypeOf(ch).String(), i+1))
+			}
+			elemType := reflect.TypeOf(ch).Elem()
+			if seen[elemType] {
+				panic(fmt.Sprintf("All channels must have mutually different element types, got '%s' twice",
+					elemType.String()))
+			}
+			seen[elemType] = true
+		}
+	}
+}
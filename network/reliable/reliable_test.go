@@ -0,0 +1,159 @@
+package reliable
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"../bcast"
+	"../peers"
+)
+
+func TestIsNewSuppressesDuplicates(t *testing.T) {
+	receivedFrom := make(map[string]*received)
+	for _, c := range []struct {
+		from  string
+		epoch int64
+		seq   uint64
+		isNew bool
+	}{
+		{"a", 1, 1, true},
+		{"a", 1, 1, false},
+		{"a", 1, 3, true},
+		{"a", 1, 2, true},
+		{"a", 1, 3, false},
+		{"a", 1, 2, false},
+		{"b", 1, 1, true},
+		// A restarted sender counts from 1 again.
+		{"a", 2, 1, true},
+		{"a", 2, 1, false},
+	} {
+		packet := Packet{From: c.from, Epoch: c.epoch, Seq: c.seq}
+		if isNew := isNew(receivedFrom, packet); isNew != c.isNew {
+			t.Errorf("%s epoch %d seq %d: new %v, want %v", c.from, c.epoch, c.seq, isNew, c.isNew)
+		}
+	}
+}
+
+// Sequence numbers that never arrive do not keep the received ones in memory.
+func TestIsNewBoundsWindow(t *testing.T) {
+	receivedFrom := make(map[string]*received)
+	// Sequence number 1 and every tenth after it are lost for good.
+	for seq := uint64(2); seq <= 10*receiveWindow; seq++ {
+		if seq%10 == 1 {
+			continue
+		}
+		if !isNew(receivedFrom, Packet{From: "a", Epoch: 1, Seq: seq}) {
+			t.Fatalf("seq %d not new", seq)
+		}
+		if n := len(receivedFrom["a"].seqs); n > receiveWindow {
+			t.Fatalf("%d sequence numbers kept after seq %d, want at most %d", n, seq, receiveWindow)
+		}
+	}
+	if isNew(receivedFrom, Packet{From: "a", Epoch: 1, Seq: 1}) {
+		t.Errorf("seq 1 taken as new after falling out of the window")
+	}
+	if isNew(receivedFrom, Packet{From: "a", Epoch: 1, Seq: 10 * receiveWindow}) {
+		t.Errorf("duplicate of the last seq taken as new")
+	}
+	if !isNew(receivedFrom, Packet{From: "a", Epoch: 1, Seq: 10*receiveWindow - 9}) {
+		t.Errorf("late seq within the window not taken as new")
+	}
+}
+
+type testMessage struct {
+	N int
+}
+
+// countSent returns how many copies of the message seq sent by from arrive
+// on observed within d, and the last of them.
+func countSent(observed <-chan Packet, from string, seq uint64, d time.Duration) (int, Packet) {
+	n := 0
+	var last Packet
+	timeout := time.After(d)
+	for {
+		select {
+		case packet := <-observed:
+			if packet.From == from && packet.Seq == seq && !packet.Ack {
+				n++
+				last = packet
+			}
+		case <-timeout:
+			return n, last
+		}
+	}
+}
+
+// awaitAck returns whether from acknowledges msg on observed within d.
+func awaitAck(observed <-chan Packet, from string, msg Packet, d time.Duration) bool {
+	timeout := time.After(d)
+	for {
+		select {
+		case packet := <-observed:
+			if packet.Ack && packet.From == from && packet.To == msg.From &&
+				packet.Epoch == msg.Epoch && packet.Seq == msg.Seq {
+				return true
+			}
+		case <-timeout:
+			return false
+		}
+	}
+}
+
+// Two links on a loopback broadcast port: a message to a peer not listening
+// yet is retransmitted until the peer starts and acknowledges it, and is
+// passed on once however many copies arrive.
+func TestLinkRetransmitsUntilAcknowledged(t *testing.T) {
+	const port = 20473
+	const resendInterval = 10 * time.Millisecond
+	// Links left running by an earlier run of the test share the port, so
+	// the IDs are unique to this run.
+	run := time.Now().UnixNano()
+	a, b := fmt.Sprintf("a%d", run), fmt.Sprintf("b%d", run)
+
+	observed := make(chan Packet, 1024)
+	go bcast.Receiver(port, observed)
+	peerUpdateA := make(chan peers.PeerUpdate)
+	outgoingA := make(chan testMessage)
+	go Link(port, a, resendInterval, peerUpdateA, []interface{}{outgoingA}, nil)
+
+	peerUpdateA <- peers.PeerUpdate{Peers: []string{a, b}}
+	outgoingA <- testMessage{N: 1}
+	n, sent := countSent(observed, a, 1, 20*resendInterval)
+	if n < 2 {
+		if n == 0 {
+			t.Skip("broadcast on the loopback port not received")
+		}
+		t.Fatalf("message sent %d times while unacknowledged, want retransmissions", n)
+	}
+
+	incomingB := make(chan testMessage, 16)
+	go Link(port, b, resendInterval, make(chan peers.PeerUpdate), nil, []interface{}{incomingB})
+	select {
+	case msg := <-incomingB:
+		if msg.N != 1 {
+			t.Fatalf("received %+v, want N 1", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("message not received after the peer started")
+	}
+
+	// Let the acknowledgement arrive, then the retransmissions stop.
+	countSent(observed, a, 1, 10*resendInterval)
+	if n, _ := countSent(observed, a, 1, 20*resendInterval); n != 0 {
+		t.Errorf("message sent %d times after it was acknowledged", n)
+	}
+
+	// A copy arriving again is acknowledged, but not passed on.
+	copies := make(chan Packet)
+	go bcast.Transmitter(port, copies)
+	copies <- sent
+	if !awaitAck(observed, b, sent, 10*resendInterval) {
+		t.Errorf("copy not acknowledged")
+	}
+	select {
+	case msg := <-incomingB:
+		t.Errorf("duplicate %+v passed on", msg)
+	case <-time.After(10 * resendInterval):
+	}
+}
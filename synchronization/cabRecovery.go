@@ -0,0 +1,53 @@
+package synchronization
+
+import (
+	"time"
+
+	"../elevio"
+	"../esm"
+)
+
+// CabOrderRecovery carries the last known cab orders of elevator ID, sent by
+// its peers when it reconnects so cab orders survive the loss of its backup.
+type CabOrderRecovery struct {
+	ID        string
+	CabOrders []int
+}
+
+// pendingRecovery is a recovery being sent to a reconnected peer until it
+// shows the cab orders in its local queue, or until it expires.
+type pendingRecovery struct {
+	recovery CabOrderRecovery
+	expires  time.Time
+}
+
+func hasCabOrders(cabOrders []int) bool {
+	for _, isOrder := range cabOrders {
+		if isOrder == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// cabQueue returns the cab orders in the local queue of elev, or nil if the
+// queue has none, as in malformed data from a peer.
+func cabQueue(elev esm.ElevData) []int {
+	if len(elev.LocalQueue) <= elevio.BT_Cab {
+		return nil
+	}
+	return elev.LocalQueue[elevio.BT_Cab]
+}
+
+// hasRecovered returns whether elev has every cab order in cabOrders in its
+// local queue. A queue with fewer floors than cabOrders does not have the
+// orders above its top floor.
+func hasRecovered(elev esm.ElevData, cabOrders []int) bool {
+	queue := cabQueue(elev)
+	for floor, isOrder := range cabOrders {
+		if isOrder == 1 && (floor >= len(queue) || queue[floor] != 1) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,29 @@
+package synchronization
+
+import (
+	"testing"
+
+	"../esm"
+)
+
+func TestHasRecovered(t *testing.T) {
+	for _, c := range []struct {
+		name       string
+		localQueue [][]int
+		cabOrders  []int
+		want       bool
+	}{
+		{"all held", [][]int{{0, 0}, {0, 0}, {1, 1}}, []int{1, 1}, true},
+		{"one missing", [][]int{{0, 0}, {0, 0}, {1, 0}}, []int{1, 1}, false},
+		{"no orders", [][]int{{0, 0}, {0, 0}, {0, 0}}, []int{0, 0}, true},
+		{"fewer floors", [][]int{{0}, {0}, {1}}, []int{1, 1}, false},
+		{"fewer floors without orders above", [][]int{{0}, {0}, {1}}, []int{1, 0}, true},
+		{"no cab row", [][]int{{0, 0}}, []int{1, 0}, false},
+		{"no queue", nil, []int{0, 1}, false},
+		{"no queue or orders", nil, nil, true},
+	} {
+		if got := hasRecovered(esm.ElevData{LocalQueue: c.localQueue}, c.cabOrders); got != c.want {
+			t.Errorf("%s: hasRecovered %v, want %v", c.name, got, c.want)
+		}
+	}
+}
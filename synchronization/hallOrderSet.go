@@ -0,0 +1,121 @@
+package synchronization
+
+import (
+	"../config"
+	"../elevio"
+	"../esm"
+)
+
+// HallOrderSet is the synchronized set of hall orders, a state-based CRDT.
+//
+// Each order has a version only counting up through the phases of
+// esm.OrderVersion, and is in the set while unconfirmed or confirmed. The
+// version is the causal context of the order: adding and removing it bumps
+// the version, so a removal overrides every add it has seen, and only a later
+// add brings the order back. Merge takes the highest version of each order,
+// which is commutative, associative and idempotent, so sets converge whatever
+// order messages arrive in, and however often.
+//
+// It is indexed like the local queue, the cab row is not used.
+type HallOrderSet [][]esm.OrderVersion
+
+// NewHallOrderSet returns an empty set for numFloors floors.
+func NewHallOrderSet(numFloors int) HallOrderSet {
+	set := make(HallOrderSet, config.NumButtonTypes)
+	for i := range set {
+		set[i] = make([]esm.OrderVersion, numFloors)
+	}
+	return set
+}
+
+// Copy returns a copy of set.
+func (set HallOrderSet) Copy() HallOrderSet {
+	setCopy := make(HallOrderSet, len(set))
+	for i := range set {
+		setCopy[i] = append([]esm.OrderVersion(nil), set[i]...)
+	}
+	return setCopy
+}
+
+// Contains returns whether order is in the set.
+func (set HallOrderSet) Contains(order elevio.ButtonEvent) bool {
+	phase := set[order.Button][order.Floor].Phase()
+	return phase == esm.OrderUnconfirmed || phase == esm.OrderConfirmed
+}
+
+// Add adds order as unconfirmed. An order being served can not be added until
+// every online elevator has seen it served, Add returns false then.
+func (set HallOrderSet) Add(order elevio.ButtonEvent) bool {
+	switch set[order.Button][order.Floor].Phase() {
+	case esm.OrderNone:
+		set[order.Button][order.Floor]++
+	case esm.OrderServed:
+		return false
+	}
+	return true
+}
+
+// Remove removes a confirmed order as served, and returns whether it did.
+func (set HallOrderSet) Remove(order elevio.ButtonEvent) bool {
+	if set[order.Button][order.Floor].Phase() != esm.OrderConfirmed {
+		return false
+	}
+	set[order.Button][order.Floor]++
+	return true
+}
+
+// Merge merges other into set.
+func (set HallOrderSet) Merge(other HallOrderSet) {
+	for buttonNr := range set {
+		if buttonNr >= len(other) {
+			break
+		}
+		for floorNr := range set[buttonNr] {
+			if floorNr < len(other[buttonNr]) {
+				set[buttonNr][floorNr] = esm.MergeOrderVersions(set[buttonNr][floorNr], other[buttonNr][floorNr])
+			}
+		}
+	}
+}
+
+// Advance confirms unconfirmed orders and clears served orders that every set
+// in seenBy has seen. seenBy are the sets of the other online elevators. An
+// order missing from a set, as in a set with fewer floors, is not seen.
+func (set HallOrderSet) Advance(seenBy []HallOrderSet) {
+	for buttonNr := range set {
+		for floorNr, version := range set[buttonNr] {
+			phase := version.Phase()
+			if phase != esm.OrderUnconfirmed && phase != esm.OrderServed {
+				continue
+			}
+			seen := true
+			for _, other := range seenBy {
+				if buttonNr >= len(other) || floorNr >= len(other[buttonNr]) ||
+					other[buttonNr][floorNr] < version {
+					seen = false
+				}
+			}
+			if seen {
+				set[buttonNr][floorNr]++
+			}
+		}
+	}
+}
+
+// Equal returns whether set and other hold the same versions.
+func (set HallOrderSet) Equal(other HallOrderSet) bool {
+	if len(set) != len(other) {
+		return false
+	}
+	for buttonNr := range set {
+		if len(set[buttonNr]) != len(other[buttonNr]) {
+			return false
+		}
+		for floorNr := range set[buttonNr] {
+			if set[buttonNr][floorNr] != other[buttonNr][floorNr] {
+				return false
+			}
+		}
+	}
+	return true
+}
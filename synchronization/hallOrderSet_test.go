@@ -0,0 +1,403 @@
+package synchronization
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+
+	"../elevio"
+	"../esm"
+)
+
+const testNumFloors = 4
+
+func randomHallOrderSet(rng *rand.Rand) HallOrderSet {
+	set := NewHallOrderSet(testNumFloors)
+	for buttonNr := range set {
+		for floorNr := range set[buttonNr] {
+			set[buttonNr][floorNr] = esm.OrderVersion(rng.Intn(12))
+		}
+	}
+	return set
+}
+
+func merged(a HallOrderSet, b HallOrderSet) HallOrderSet {
+	set := a.Copy()
+	set.Merge(b)
+	return set
+}
+
+func TestHallOrderSetMergeLaws(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 10000; i++ {
+		a, b, c := randomHallOrderSet(rng), randomHallOrderSet(rng), randomHallOrderSet(rng)
+		if !merged(a, b).Equal(merged(b, a)) {
+			t.Fatalf("not commutative: %v, %v", a, b)
+		}
+		if !merged(merged(a, b), c).Equal(merged(a, merged(b, c))) {
+			t.Fatalf("not associative: %v, %v, %v", a, b, c)
+		}
+		if !merged(a, a).Equal(a) {
+			t.Fatalf("not idempotent: %v", a)
+		}
+		ab := merged(a, b)
+		for buttonNr := range ab {
+			for floorNr := range ab[buttonNr] {
+				if ab[buttonNr][floorNr] < a[buttonNr][floorNr] || ab[buttonNr][floorNr] < b[buttonNr][floorNr] {
+					t.Fatalf("merge of %v and %v went back: %v", a, b, ab)
+				}
+			}
+		}
+	}
+}
+
+// simPeer is an elevator keeping its hall orders with the helpers Synchronize
+// uses. elevData[0] is the peer itself, elevData[slot(i, j)] what peer i last
+// received from peer j. heard holds whether peer i has received from peer j,
+// or is peer j.
+type simPeer struct {
+	elevData []esm.ElevData
+	pending  [][]bool
+	heard    []bool
+}
+
+func (p *simPeer) isSynced() bool {
+	for _, heard := range p.heard {
+		if !heard {
+			return false
+		}
+	}
+	return true
+}
+
+// simMessage is the esm.ElevData of a peer in flight to peer to.
+type simMessage struct {
+	to   int
+	data []byte
+}
+
+type hallOrderSim struct {
+	t     *testing.T
+	rng   *rand.Rand
+	peers []*simPeer
+	now   time.Time
+}
+
+func slot(i int, j int) int {
+	switch {
+	case i == j:
+		return 0
+	case j < i:
+		return j + 1
+	}
+	return j
+}
+
+func newHallOrderSim(t *testing.T, seed int64, numPeers int) *hallOrderSim {
+	sim := &hallOrderSim{t: t, rng: rand.New(rand.NewSource(seed)), now: time.Unix(1000, 0)}
+	for i := 0; i < numPeers; i++ {
+		p := &simPeer{
+			elevData: make([]esm.ElevData, numPeers),
+			pending:  make([][]bool, len(NewHallOrderSet(testNumFloors))),
+			heard:    make([]bool, numPeers),
+		}
+		p.heard[i] = true
+		for j := 0; j < numPeers; j++ {
+			p.elevData[slot(i, j)] = *newElevData(fmt.Sprintf("peer%d", j), testNumFloors)
+			p.elevData[slot(i, j)].Online = true
+		}
+		for k := range p.pending {
+			p.pending[k] = make([]bool, testNumFloors)
+		}
+		sim.peers = append(sim.peers, p)
+	}
+	return sim
+}
+
+func (sim *hallOrderSim) encode(from int, to int) simMessage {
+	self := sentElevData(sim.peers[from].elevData[0], sim.now)
+	data, err := json.Marshal(esm.ElevData{ID: self.ID, OrderStatus: self.OrderStatus, ConfirmedAt: self.ConfirmedAt})
+	if err != nil {
+		sim.t.Fatal(err)
+	}
+	return simMessage{to: to, data: data}
+}
+
+// update runs the OrderStatusUpdate step of Synchronize at peer i, checking
+// that no version goes back.
+func (sim *hallOrderSim) update(i int) {
+	p := sim.peers[i]
+	before := HallOrderSet(p.elevData[0].OrderStatus).Copy()
+	updateHallOrders(p.elevData, p.pending, p.isSynced(), sim.now)
+	after := HallOrderSet(p.elevData[0].OrderStatus)
+	for buttonNr := range before {
+		for floorNr := range before[buttonNr] {
+			if after[buttonNr][floorNr] < before[buttonNr][floorNr] {
+				sim.t.Fatalf("peer%d: Floor: %d Button: %d went back from version %d to %d",
+					i, floorNr, buttonNr, before[buttonNr][floorNr], after[buttonNr][floorNr])
+			}
+		}
+	}
+}
+
+func (sim *hallOrderSim) deliver(msg simMessage) {
+	var elevData esm.ElevData
+	if err := json.Unmarshal(msg.data, &elevData); err != nil {
+		sim.t.Fatal(err)
+	}
+	elevData = receivedElevData(elevData, sim.now)
+	var from int
+	if _, err := fmt.Sscanf(elevData.ID, "peer%d", &from); err != nil {
+		sim.t.Fatal(err)
+	}
+	sim.peers[msg.to].heard[from] = true
+	view := &sim.peers[msg.to].elevData[slot(msg.to, from)]
+	view.OrderStatus = elevData.OrderStatus
+	view.ConfirmedAt = elevData.ConfirmedAt
+	sim.update(msg.to)
+}
+
+func (sim *hallOrderSim) randomHallOrder() elevio.ButtonEvent {
+	for {
+		order := elevio.MakeButtonEvent(sim.rng.Intn(elevio.BT_Cab), sim.rng.Intn(testNumFloors))
+		if !(order.Button == elevio.BT_HallUp && order.Floor == testNumFloors-1) &&
+			!(order.Button == elevio.BT_HallDown && order.Floor == 0) {
+			return order
+		}
+	}
+}
+
+// run presses and serves random hall orders at random peers while messages
+// are dropped with probability dropRate, duplicated with probability dupRate
+// and delivered in random order. Then the presses stop, the messages left are
+// delivered, and the peers exchange their state until they agree.
+func (sim *hallOrderSim) run(steps int, dropRate float64, dupRate float64) {
+	var inFlight []simMessage
+	for step := 0; step < steps; step++ {
+		sim.now = sim.now.Add(10 * time.Millisecond)
+		i := sim.rng.Intn(len(sim.peers))
+		self := sim.peers[i].elevData[0]
+		switch sim.rng.Intn(4) {
+		case 0:
+			pressHallOrder(self, sim.peers[i].pending, sim.randomHallOrder(), sim.peers[i].isSynced(), sim.now)
+			sim.update(i)
+		case 1:
+			hallOrders := HallOrderSet(self.OrderStatus)
+			order := sim.randomHallOrder()
+			if hallOrders[order.Button][order.Floor].Phase() == esm.OrderConfirmed {
+				hallOrders.Remove(order)
+				sim.update(i)
+			}
+		case 2:
+			for to := range sim.peers {
+				if to == i || sim.rng.Float64() < dropRate {
+					continue
+				}
+				msg := sim.encode(i, to)
+				inFlight = append(inFlight, msg)
+				if sim.rng.Float64() < dupRate {
+					inFlight = append(inFlight, msg)
+				}
+			}
+		case 3:
+			if len(inFlight) == 0 {
+				break
+			}
+			k := sim.rng.Intn(len(inFlight))
+			msg := inFlight[k]
+			inFlight = append(inFlight[:k], inFlight[k+1:]...)
+			sim.deliver(msg)
+		}
+	}
+
+	for _, k := range sim.rng.Perm(len(inFlight)) {
+		sim.deliver(inFlight[k])
+	}
+	const maxRounds = 20
+	for round := 0; round < maxRounds; round++ {
+		if sim.hasConverged() {
+			return
+		}
+		for from := range sim.peers {
+			for to := range sim.peers {
+				if to != from {
+					sim.deliver(sim.encode(from, to))
+				}
+			}
+		}
+	}
+	sim.t.Fatalf("not converged after %d rounds: %v", maxRounds, sim)
+}
+
+// hasConverged returns whether all peers hold the same hall orders and
+// confirmation times, with every order absent or confirmed and no presses
+// waiting.
+func (sim *hallOrderSim) hasConverged() bool {
+	first := sim.peers[0].elevData[0]
+	for _, p := range sim.peers {
+		self := p.elevData[0]
+		if !HallOrderSet(self.OrderStatus).Equal(first.OrderStatus) {
+			return false
+		}
+		for buttonNr := range self.OrderStatus {
+			for floorNr, version := range self.OrderStatus[buttonNr] {
+				if phase := version.Phase(); phase == esm.OrderUnconfirmed || phase == esm.OrderServed ||
+					p.pending[buttonNr][floorNr] ||
+					self.ConfirmedAt[buttonNr][floorNr] != first.ConfirmedAt[buttonNr][floorNr] {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+func (sim *hallOrderSim) String() string {
+	s := ""
+	for _, p := range sim.peers {
+		s += fmt.Sprintf("\n  %s: %v pending: %v", p.elevData[0].ID, p.elevData[0].OrderStatus, p.pending)
+	}
+	return s
+}
+
+func TestHallOrdersConverge(t *testing.T) {
+	runs, steps := 100, 2000
+	if testing.Short() {
+		runs, steps = 10, 500
+	}
+	for _, c := range []struct {
+		numPeers int
+		dropRate float64
+		dupRate  float64
+	}{
+		{2, 0, 0},
+		{3, 0.3, 0.2},
+		{5, 0.7, 0.5},
+	} {
+		for seed := int64(1); seed <= int64(runs); seed++ {
+			t.Run(fmt.Sprintf("peers=%d/drop=%v/dup=%v/seed=%d", c.numPeers, c.dropRate, c.dupRate, seed), func(t *testing.T) {
+				newHallOrderSim(t, seed, c.numPeers).run(steps, c.dropRate, c.dupRate)
+			})
+		}
+	}
+}
+
+// A node restarting with no hall orders must not lose a press to the higher
+// version of the order held by its peers.
+func TestPressAfterRestartIsKept(t *testing.T) {
+	sim := newHallOrderSim(t, 1, 2)
+	order := elevio.MakeButtonEvent(int(elevio.BT_HallUp), 1)
+	peer1 := HallOrderSet(sim.peers[1].elevData[0].OrderStatus)
+	peer1[order.Button][order.Floor] = 20
+	sim.peers[0].heard[1] = false
+
+	self := sim.peers[0].elevData[0]
+	if pressHallOrder(self, sim.peers[0].pending, order, sim.peers[0].isSynced(), sim.now) {
+		t.Fatalf("press registered before the state of the peer was received")
+	}
+	sim.deliver(sim.encode(1, 0))
+	if version := self.OrderStatus[order.Button][order.Floor]; version != 21 {
+		t.Fatalf("press registered as version %d after merging version 20, want 21", version)
+	}
+
+	for round := 0; round < 3; round++ {
+		sim.deliver(sim.encode(0, 1))
+		sim.deliver(sim.encode(1, 0))
+	}
+	for i, p := range sim.peers {
+		if phase := p.elevData[0].OrderStatus[order.Button][order.Floor].Phase(); phase != esm.OrderConfirmed {
+			t.Errorf("peer%d: order is in phase %d, want confirmed", i, phase)
+		}
+	}
+}
+
+// Orders of a peer configured with fewer floors are not seen by it, and
+// neither advancing nor comparing its state may index past them.
+func TestPeerWithFewerFloors(t *testing.T) {
+	self := newElevData("self", 12)
+	peer := newElevData("peer", testNumFloors)
+	peer.Online = true
+	order := elevio.MakeButtonEvent(int(elevio.BT_HallDown), 10)
+	HallOrderSet(self.OrderStatus).Add(order)
+
+	HallOrderSet(self.OrderStatus).Advance([]HallOrderSet{peer.OrderStatus})
+	if phase := self.OrderStatus[order.Button][order.Floor].Phase(); phase != esm.OrderUnconfirmed {
+		t.Errorf("order beyond the floors of the peer is in phase %d, want unconfirmed", phase)
+	}
+	if hasPeerChange(*self, *peer) || hasPeerChange(*peer, *self) ||
+		hasLocalUpdate(*self, *peer) || hasLocalUpdate(*peer, *self) {
+		t.Errorf("states of 12 and %d floors taken as equal", testNumFloors)
+	}
+	if err := checkShape(*peer, 12); err == nil {
+		t.Errorf("state of %d floors accepted in a building of 12", testNumFloors)
+	}
+	if err := checkShape(*self, 12); err != nil {
+		t.Errorf("state of 12 floors rejected: %v", err)
+	}
+
+	event := OrderEvent{ID: "peer", Order: order, Version: 1}
+	if changed, err := applyOrderEvent(peer, event, testNumFloors, time.Unix(1000, 0)); changed || err == nil {
+		t.Errorf("order event at floor %d applied in a building of %d floors", order.Floor, testNumFloors)
+	}
+}
+
+// A node that restarted and confirmed an order alone holds a version below
+// that of a peer which cleared the order twice before. The order must not
+// be cleared by the merge when the peer reconnects.
+func TestConfirmedOrderKeptAfterPartition(t *testing.T) {
+	sim := newHallOrderSim(t, 1, 2)
+	order := elevio.MakeButtonEvent(int(elevio.BT_HallUp), 1)
+	p := sim.peers[0]
+	self := p.elevData[0]
+	p.heard[1] = true
+	p.elevData[1].Online = false
+
+	if !pressHallOrder(self, p.pending, order, p.isSynced(), sim.now) {
+		t.Fatalf("press not registered by a node alone")
+	}
+	sim.update(0)
+	if phase := self.OrderStatus[order.Button][order.Floor].Phase(); phase != esm.OrderConfirmed {
+		t.Fatalf("order in phase %d by a node alone, want confirmed", phase)
+	}
+	self.LocalQueue[order.Button][order.Floor] = 1
+	HallOrderSet(sim.peers[1].elevData[0].OrderStatus)[order.Button][order.Floor] = 8
+
+	p.elevData[1].Online = true
+	p.elevData[1].OrderStatus = HallOrderSet(sim.peers[1].elevData[0].OrderStatus).Copy()
+	if cleared := updateHallOrders(p.elevData, p.pending, p.isSynced(), sim.now); len(cleared) != 0 {
+		t.Errorf("orders %v cleared by merging version 8", cleared)
+	}
+	if version := self.OrderStatus[order.Button][order.Floor]; version != 9 {
+		t.Errorf("order at version %d after merging version 8, want 9", version)
+	}
+
+	for round := 0; round < 3; round++ {
+		sim.deliver(sim.encode(0, 1))
+		sim.deliver(sim.encode(1, 0))
+	}
+	for i, p := range sim.peers {
+		if phase := p.elevData[0].OrderStatus[order.Button][order.Floor].Phase(); phase != esm.OrderConfirmed {
+			t.Errorf("peer%d: order is in phase %d, want confirmed", i, phase)
+		}
+	}
+}
+
+// ConfirmedAt sent by a node whose clock is an hour ahead arrives as the same
+// time by the local clock, after the delay of the message.
+func TestConfirmedAtSentAsAge(t *testing.T) {
+	const delay = 20 * time.Millisecond
+	now := time.Unix(1000, 0)
+	skew := time.Hour
+	sender := newElevData("sender", testNumFloors)
+	sender.ConfirmedAt[elevio.BT_HallUp][1] = unixMillis(now.Add(skew - 3*time.Second))
+
+	received := receivedElevData(sentElevData(*sender, now.Add(skew)), now.Add(delay))
+	if at, want := received.ConfirmedAt[elevio.BT_HallUp][1], unixMillis(now.Add(delay-3*time.Second)); at != want {
+		t.Errorf("received ConfirmedAt %d, want %d", at, want)
+	}
+	if at := received.ConfirmedAt[elevio.BT_HallDown][1]; at != 0 {
+		t.Errorf("received ConfirmedAt %d without an order, want 0", at)
+	}
+}
@@ -0,0 +1,57 @@
+package synchronization
+
+import (
+	"fmt"
+	"time"
+
+	"../config"
+	"../elevio"
+	"../esm"
+)
+
+// OrderEvent is a new version of a hall order at elevator ID, sent to the
+// peers as soon as an order is pressed or served instead of waiting for the
+// next state broadcast. It is merged like the versions of a state broadcast,
+// so late and repeated events do no harm.
+type OrderEvent struct {
+	ID      string
+	Order   elevio.ButtonEvent
+	Version esm.OrderVersion
+	// ConfirmedAt is sent as an age, see confirmationAge.
+	ConfirmedAt int64
+}
+
+// applyOrderEvent merges event into the data known of the elevator sending it,
+// received at now, and returns whether that changed the data. It returns an
+// error for an order outside a building of numFloors floors, or data of
+// another shape.
+func applyOrderEvent(elev *esm.ElevData, event OrderEvent, numFloors int, now time.Time) (bool, error) {
+	if err := checkShape(*elev, numFloors); err != nil {
+		return false, err
+	}
+	button, floor := int(event.Order.Button), event.Order.Floor
+	if button < 0 || button >= config.NumButtonTypes || floor < 0 || floor >= numFloors {
+		return false, fmt.Errorf("order %+v outside %d floors", event.Order, numFloors)
+	}
+	if elev.OrderStatus[button][floor] >= event.Version {
+		return false, nil
+	}
+	elev.OrderStatus[button][floor] = event.Version
+	elev.ConfirmedAt[button][floor] = confirmationTime(event.ConfirmedAt, now)
+	return true, nil
+}
+
+// sendOrderEvent sends the version of order at self to the peers at now, if
+// events are enabled.
+func sendOrderEvent(channels Channels, cfg config.Config, self esm.ElevData, order elevio.ButtonEvent, now time.Time) {
+	if !cfg.ReliableEvents {
+		return
+	}
+	event := OrderEvent{
+		ID:          self.ID,
+		Order:       order,
+		Version:     self.OrderStatus[order.Button][order.Floor],
+		ConfirmedAt: confirmationAge(self.ConfirmedAt[order.Button][order.Floor], now),
+	}
+	go func() { channels.OutgoingOrderEvent <- event }()
+}
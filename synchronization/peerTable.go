@@ -0,0 +1,174 @@
+package synchronization
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"../config"
+	"../esm"
+)
+
+// peerTable holds the data of this elevator and its peers, keyed by ID. It
+// holds at most maxElevators elevators, this one included, and forgets peers
+// that have been lost for longer than evictAfter.
+type peerTable struct {
+	myID   string
+	elevs  map[string]*esm.ElevData
+	lostAt map[string]time.Time
+	// unheard holds the peers no state has been received from since they
+	// joined.
+	unheard      map[string]bool
+	maxElevators int
+	evictAfter   time.Duration
+	numFloors    int
+}
+
+func newPeerTable(myID string, cfg config.Config) *peerTable {
+	table := &peerTable{
+		myID:         myID,
+		elevs:        make(map[string]*esm.ElevData),
+		lostAt:       make(map[string]time.Time),
+		unheard:      make(map[string]bool),
+		maxElevators: cfg.MaxNumElevators,
+		evictAfter:   cfg.PeerEvictionDuration,
+		numFloors:    cfg.NumFloors,
+	}
+	table.elevs[myID] = newElevData(myID, cfg.NumFloors)
+	return table
+}
+
+func newElevData(id string, numFloors int) *esm.ElevData {
+	elev := &esm.ElevData{
+		ID:          id,
+		OrderStatus: NewHallOrderSet(numFloors),
+		ConfirmedAt: make([][]int64, config.NumButtonTypes),
+		LocalQueue:  make([][]int, config.NumButtonTypes),
+		Online:      false,
+	}
+	for i := 0; i < config.NumButtonTypes; i++ {
+		elev.ConfirmedAt[i] = make([]int64, numFloors)
+		elev.LocalQueue[i] = make([]int, numFloors)
+	}
+	return elev
+}
+
+// self returns the data of this elevator.
+func (table *peerTable) self() *esm.ElevData {
+	return table.elevs[table.myID]
+}
+
+func (table *peerTable) get(id string) (*esm.ElevData, bool) {
+	elev, found := table.elevs[id]
+	return elev, found
+}
+
+// join marks the elevator with id online, adding it to the table if it is
+// new. A full table makes room by removing the peer lost the longest, as a
+// restarted or renamed peer may still be waiting to be evicted. It returns an
+// error if the table is full of online elevators.
+func (table *peerTable) join(id string) (*esm.ElevData, error) {
+	elev, found := table.elevs[id]
+	if !found {
+		if len(table.elevs) >= table.maxElevators {
+			lostID, found := table.lostLongest()
+			if !found {
+				return nil, fmt.Errorf("peer table is full with %d online elevators", len(table.elevs))
+			}
+			table.remove(lostID)
+		}
+		elev = newElevData(id, table.numFloors)
+		table.elevs[id] = elev
+	}
+	if !elev.Online && id != table.myID {
+		table.unheard[id] = true
+	}
+	elev.Online = true
+	delete(table.lostAt, id)
+	return elev, nil
+}
+
+// heard records that the state of the elevator with id has been received,
+// and returns whether it was the first time since it joined.
+func (table *peerTable) heard(id string) bool {
+	if !table.unheard[id] {
+		return false
+	}
+	delete(table.unheard, id)
+	return true
+}
+
+// isSynced returns whether the state of every online peer has been received
+// since it joined.
+func (table *peerTable) isSynced() bool {
+	for id := range table.unheard {
+		if elev, found := table.elevs[id]; found && elev.Online {
+			return false
+		}
+	}
+	return true
+}
+
+// leave marks the elevator with id offline from now on, and returns its data.
+func (table *peerTable) leave(id string, now time.Time) (*esm.ElevData, bool) {
+	elev, found := table.elevs[id]
+	if !found {
+		return nil, false
+	}
+	elev.Online = false
+	table.lostAt[id] = now
+	return elev, true
+}
+
+// evict removes the peers lost for longer than evictAfter, and returns their
+// IDs. This elevator is never removed.
+func (table *peerTable) evict(now time.Time) []string {
+	var evicted []string
+	for id, lostAt := range table.lostAt {
+		if id != table.myID && now.Sub(lostAt) > table.evictAfter {
+			table.remove(id)
+			evicted = append(evicted, id)
+		}
+	}
+	sort.Strings(evicted)
+	return evicted
+}
+
+// lostLongest returns the ID of the peer lost the longest, or false if no
+// peer is lost.
+func (table *peerTable) lostLongest() (string, bool) {
+	longest := ""
+	for id, lostAt := range table.lostAt {
+		if id == table.myID {
+			continue
+		}
+		if longest == "" || lostAt.Before(table.lostAt[longest]) ||
+			(lostAt.Equal(table.lostAt[longest]) && id < longest) {
+			longest = id
+		}
+	}
+	return longest, longest != ""
+}
+
+func (table *peerTable) remove(id string) {
+	delete(table.elevs, id)
+	delete(table.lostAt, id)
+	delete(table.unheard, id)
+}
+
+// elevators returns the elevators of the table, this one first and the peers
+// sorted by ID. The slices of the returned data are shared with the table.
+func (table *peerTable) elevators() []esm.ElevData {
+	elevData := []esm.ElevData{*table.self()}
+	var ids []string
+	for id := range table.elevs {
+		if id != table.myID {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		elevData = append(elevData, *table.elevs[id])
+	}
+	return elevData
+}
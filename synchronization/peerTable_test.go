@@ -0,0 +1,88 @@
+package synchronization
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"../config"
+)
+
+func TestPeerTable(t *testing.T) {
+	start := time.Unix(1000, 0)
+	type step struct {
+		op      string // "join", "leave" or "evict"
+		id      string
+		at      time.Duration
+		wantErr bool
+		// evicted holds the IDs evict returns.
+		evicted []string
+		// ids holds the IDs in the table after the step.
+		ids []string
+	}
+	for _, c := range []struct {
+		name  string
+		steps []step
+	}{
+		{"join and leave", []step{
+			{op: "join", id: "b", ids: []string{"a", "b"}},
+			{op: "join", id: "c", ids: []string{"a", "b", "c"}},
+			{op: "leave", id: "b", ids: []string{"a", "b", "c"}},
+			{op: "join", id: "b", ids: []string{"a", "b", "c"}},
+		}},
+		{"eviction", []step{
+			{op: "join", id: "b", ids: []string{"a", "b"}},
+			{op: "join", id: "c", ids: []string{"a", "b", "c"}},
+			{op: "leave", id: "b", ids: []string{"a", "b", "c"}},
+			{op: "evict", at: time.Second, ids: []string{"a", "b", "c"}},
+			{op: "leave", id: "c", at: time.Second, ids: []string{"a", "b", "c"}},
+			{op: "evict", at: 3 * time.Second, evicted: []string{"b"}, ids: []string{"a", "c"}},
+			{op: "evict", at: 10 * time.Second, evicted: []string{"c"}, ids: []string{"a"}},
+		}},
+		{"full of online elevators", []step{
+			{op: "join", id: "b", ids: []string{"a", "b"}},
+			{op: "join", id: "c", ids: []string{"a", "b", "c"}},
+			{op: "join", id: "d", wantErr: true, ids: []string{"a", "b", "c"}},
+		}},
+		{"full with lost peers", []step{
+			{op: "join", id: "b", ids: []string{"a", "b"}},
+			{op: "join", id: "c", ids: []string{"a", "b", "c"}},
+			{op: "leave", id: "c", ids: []string{"a", "b", "c"}},
+			{op: "leave", id: "b", at: time.Second, ids: []string{"a", "b", "c"}},
+			{op: "join", id: "d", at: time.Second, ids: []string{"a", "b", "d"}},
+			{op: "join", id: "e", at: time.Second, ids: []string{"a", "d", "e"}},
+			{op: "join", id: "f", at: time.Second, wantErr: true, ids: []string{"a", "d", "e"}},
+		}},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			cfg := config.Default()
+			cfg.MaxNumElevators = 3
+			cfg.PeerEvictionDuration = 2 * time.Second
+			table := newPeerTable("a", cfg)
+			for i, s := range c.steps {
+				now := start.Add(s.at)
+				switch s.op {
+				case "join":
+					if _, err := table.join(s.id); (err != nil) != s.wantErr {
+						t.Fatalf("step %d: join %s: error %v, want error %v", i, s.id, err, s.wantErr)
+					}
+				case "leave":
+					if _, found := table.leave(s.id, now); !found {
+						t.Fatalf("step %d: leave %s: not found", i, s.id)
+					}
+				case "evict":
+					if evicted := table.evict(now); !reflect.DeepEqual(evicted, s.evicted) {
+						t.Fatalf("step %d: evicted %q, want %q", i, evicted, s.evicted)
+					}
+				}
+				var ids []string
+				for _, elev := range table.elevators() {
+					ids = append(ids, elev.ID)
+				}
+				if !reflect.DeepEqual(ids, s.ids) {
+					t.Fatalf("step %d: %s %s: table holds %q, want %q", i, s.op, s.id, ids, s.ids)
+				}
+			}
+		})
+	}
+}
@@ -1,20 +1,61 @@
 package synchronization
 
 import (
+	"fmt"
+	"time"
+
 	"../config"
+	"../elevio"
 	"../esm"
 )
 
+// checkShape returns an error unless the local queue, order versions and
+// confirmation times of elev hold numFloors floors of every button type. Data
+// from a peer configured with another number of floors fails it.
+func checkShape(elev esm.ElevData, numFloors int) error {
+	if len(elev.LocalQueue) != config.NumButtonTypes ||
+		len(elev.OrderStatus) != config.NumButtonTypes ||
+		len(elev.ConfirmedAt) != config.NumButtonTypes {
+		return fmt.Errorf("orders of %d, %d and %d button types, want %d",
+			len(elev.LocalQueue), len(elev.OrderStatus), len(elev.ConfirmedAt), config.NumButtonTypes)
+	}
+	for i := 0; i < config.NumButtonTypes; i++ {
+		if len(elev.LocalQueue[i]) != numFloors ||
+			len(elev.OrderStatus[i]) != numFloors ||
+			len(elev.ConfirmedAt[i]) != numFloors {
+			return fmt.Errorf("orders of %d, %d and %d floors, want %d",
+				len(elev.LocalQueue[i]), len(elev.OrderStatus[i]), len(elev.ConfirmedAt[i]), numFloors)
+		}
+	}
+	return nil
+}
+
+// hasSameShape returns whether the orders of elev1 and elev2 have the same
+// number of floors of every button type.
+func hasSameShape(elev1 esm.ElevData, elev2 esm.ElevData) bool {
+	if len(elev1.LocalQueue) != config.NumButtonTypes {
+		return false
+	}
+	numFloors := len(elev1.LocalQueue[0])
+	return checkShape(elev1, numFloors) == nil && checkShape(elev2, numFloors) == nil
+}
+
 func hasPeerChange(elev1 esm.ElevData, elev2 esm.ElevData) bool {
+	if !hasSameShape(elev1, elev2) {
+		return false
+	}
 	if elev1.State != elev2.State ||
 		elev1.HeadingDir != elev2.HeadingDir ||
-		elev1.Floor != elev2.Floor {
+		elev1.Floor != elev2.Floor ||
+		elev1.Obstructed != elev2.Obstructed ||
+		elev1.MotorFault != elev2.MotorFault {
 		return false
 	}
 	for i := 0; i < config.NumButtonTypes; i++ {
-		for j := 0; j < config.NumFloors; j++ {
+		for j := range elev1.LocalQueue[i] {
 			if elev1.LocalQueue[i][j] != elev2.LocalQueue[i][j] ||
-				elev1.OrderStatus[i][j] != elev2.OrderStatus[i][j] {
+				elev1.OrderStatus[i][j] != elev2.OrderStatus[i][j] ||
+				elev1.ConfirmedAt[i][j] != elev2.ConfirmedAt[i][j] {
 				return false
 			}
 		}
@@ -23,13 +64,23 @@ func hasPeerChange(elev1 esm.ElevData, elev2 esm.ElevData) bool {
 }
 
 func hasLocalUpdate(elev1 esm.ElevData, elev2 esm.ElevData) bool {
+	if len(elev2.LocalQueue) != len(elev1.LocalQueue) {
+		return false
+	}
+	for i := range elev1.LocalQueue {
+		if len(elev2.LocalQueue[i]) != len(elev1.LocalQueue[i]) {
+			return false
+		}
+	}
 	if elev1.State != elev2.State ||
 		elev1.HeadingDir != elev2.HeadingDir ||
-		elev1.Floor != elev2.Floor {
+		elev1.Floor != elev2.Floor ||
+		elev1.Obstructed != elev2.Obstructed ||
+		elev1.MotorFault != elev2.MotorFault {
 		return false
 	}
-	for i := 0; i < config.NumButtonTypes; i++ {
-		for j := 0; j < config.NumFloors; j++ {
+	for i := range elev1.LocalQueue {
+		for j := range elev1.LocalQueue[i] {
 			if elev1.LocalQueue[i][j] != elev2.LocalQueue[i][j] {
 				return false
 			}
@@ -38,12 +89,154 @@ func hasLocalUpdate(elev1 esm.ElevData, elev2 esm.ElevData) bool {
 	return true
 }
 
-func isAlone(elevData []esm.ElevData) bool {
+func unixMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
 
-	for i := range elevData {
-		if elevData[0].ID != elevData[i].ID && elevData[i].Online {
-			return false
+// confirmationAge returns confirmedAt, in unix milliseconds by the local
+// clock, as the milliseconds from it to now plus one, which is how it is sent
+// to the peers. 0 means there is no order, and stays 0.
+func confirmationAge(confirmedAt int64, now time.Time) int64 {
+	if confirmedAt == 0 {
+		return 0
+	}
+	if age := unixMillis(now) - confirmedAt + 1; age > 0 {
+		return age
+	}
+	return 1
+}
+
+// confirmationTime turns an age sent by a peer and received at now back into
+// unix milliseconds by the local clock.
+func confirmationTime(age int64, now time.Time) int64 {
+	if age <= 0 {
+		return 0
+	}
+	return unixMillis(now) - age + 1
+}
+
+// sentElevData returns a copy of elev to broadcast at now, with ConfirmedAt
+// as ages, see confirmationAge.
+func sentElevData(elev esm.ElevData, now time.Time) esm.ElevData {
+	var sent esm.ElevData
+	esm.DeepCopy(&sent, &elev)
+	for i := range sent.ConfirmedAt {
+		for j, confirmedAt := range sent.ConfirmedAt[i] {
+			sent.ConfirmedAt[i][j] = confirmationAge(confirmedAt, now)
 		}
 	}
+	return sent
+}
+
+// receivedElevData returns a copy of elev, broadcast by a peer and received
+// at now, with ConfirmedAt turned back into unix milliseconds by the local
+// clock.
+func receivedElevData(elev esm.ElevData, now time.Time) esm.ElevData {
+	var received esm.ElevData
+	esm.DeepCopy(&received, &elev)
+	for i := range received.ConfirmedAt {
+		for j, age := range received.ConfirmedAt[i] {
+			received.ConfirmedAt[i][j] = confirmationTime(age, now)
+		}
+	}
+	return received
+}
+
+// pressHallOrder registers a press of order at self, and returns whether it
+// added the order. A press while the order is being served is kept in pending,
+// as is a press before the state of every online peer is known, when a peer may
+// have a higher version overriding it.
+func pressHallOrder(self esm.ElevData, pending [][]bool, order elevio.ButtonEvent, synced bool, now time.Time) bool {
+	hallOrders := HallOrderSet(self.OrderStatus)
+	if !synced {
+		pending[order.Button][order.Floor] = true
+		return false
+	}
+	if hallOrders.Contains(order) {
+		return false
+	}
+	if !hallOrders.Add(order) {
+		pending[order.Button][order.Floor] = true
+		return false
+	}
+	self.ConfirmedAt[order.Button][order.Floor] = unixMillis(now)
 	return true
 }
+
+// updateHallOrders merges the hall orders of the peers in elevData into the
+// hall orders of elevData[0], advances the orders every online peer has seen,
+// and registers the pending presses once synced and the orders are not being
+// served. It returns the orders cleared, leaving out confirmed orders in the
+// local queue that a merge dropped, which are pressed again.
+func updateHallOrders(elevData []esm.ElevData, pending [][]bool, synced bool, now time.Time) []elevio.ButtonEvent {
+	self := elevData[0]
+	hallOrders := HallOrderSet(self.OrderStatus)
+	oldHallOrders := hallOrders.Copy()
+
+	var seenBy []HallOrderSet
+	for _, elev := range elevData[1:] {
+		hallOrders.Merge(elev.OrderStatus)
+		if elev.Online {
+			seenBy = append(seenBy, elev.OrderStatus)
+		}
+	}
+	hallOrders.Advance(seenBy)
+
+	var cleared []elevio.ButtonEvent
+	for buttonNr := range hallOrders {
+		for floorNr := range hallOrders[buttonNr] {
+			order := elevio.MakeButtonEvent(buttonNr, floorNr)
+			oldVersion := oldHallOrders[buttonNr][floorNr]
+			cycleChanged := hallOrders[buttonNr][floorNr].Cycle() != oldVersion.Cycle()
+			if cycleChanged {
+				self.ConfirmedAt[buttonNr][floorNr] = 0
+			}
+			// Versions start over when a node restarts, so a peer that never
+			// saw a confirmed order may hold a later cycle of it. An order
+			// dropped that way while still in the local queue is pressed again.
+			dropped := cycleChanged && oldVersion.Phase() == esm.OrderConfirmed &&
+				buttonNr < len(self.LocalQueue) && floorNr < len(self.LocalQueue[buttonNr]) &&
+				self.LocalQueue[buttonNr][floorNr] == 1
+			if dropped {
+				pending[buttonNr][floorNr] = true
+			}
+			if synced && pending[buttonNr][floorNr] {
+				switch {
+				case hallOrders.Contains(order):
+					pending[buttonNr][floorNr] = false
+				case hallOrders.Add(order):
+					pending[buttonNr][floorNr] = false
+					self.ConfirmedAt[buttonNr][floorNr] = unixMillis(now)
+				}
+			}
+			self.ConfirmedAt[buttonNr][floorNr] = earliestConfirmation(elevData, buttonNr, floorNr)
+
+			if oldVersion.Phase() != esm.OrderNone && cycleChanged && !dropped {
+				cleared = append(cleared, order)
+			}
+		}
+	}
+	return cleared
+}
+
+// earliestConfirmation returns the earliest time the order in elevData[0] is
+// registered at among the elevators having the same order, or 0 if there is
+// no order.
+func earliestConfirmation(elevData []esm.ElevData, buttonNr int, floorNr int) int64 {
+	version := elevData[0].OrderStatus[buttonNr][floorNr]
+	earliest := int64(0)
+	if version.Phase() == esm.OrderNone {
+		return earliest
+	}
+	for _, elev := range elevData {
+		if elev.OrderStatus[buttonNr][floorNr].Cycle() != version.Cycle() ||
+			elev.OrderStatus[buttonNr][floorNr].Phase() == esm.OrderNone {
+			continue
+		}
+		confirmedAt := elev.ConfirmedAt[buttonNr][floorNr]
+		if confirmedAt != 0 && (earliest == 0 || confirmedAt < earliest) {
+			earliest = confirmedAt
+		}
+	}
+	return earliest
+}
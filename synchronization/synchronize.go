@@ -21,35 +21,39 @@ type Channels struct {
 	CompletedOrder          chan elevio.ButtonEvent
 	HallOrder               chan elevio.ButtonEvent
 	ClearedOrderStatusOrder chan elevio.ButtonEvent
+	OutgoingCabRecovery     chan CabOrderRecovery
+	IncomingCabRecovery     chan CabOrderRecovery
+	RecoveredCabOrder       chan elevio.ButtonEvent
+	OutgoingOrderEvent      chan OrderEvent
+	IncomingOrderEvent      chan OrderEvent
 }
 
 // Synchronize is the function that continoulsy synchronize the data between
 // the contributing elevators and pass the needed information to the rest of
 // the local system on each elevator.
-func Synchronize(channels Channels, myID string) {
-	elevData := make([]esm.ElevData, 3)
-
-	initializedOrderStatus := make([][][]int, config.MaxNumElevators)
-	initializedLocalQueue := make([][][]int, config.MaxNumElevators)
-	for j := range initializedOrderStatus {
-		initializedOrderStatus[j] = make([][]int, config.NumButtonTypes)
-		initializedLocalQueue[j] = make([][]int, config.NumButtonTypes)
-		for k := range initializedOrderStatus[j] {
-			initializedOrderStatus[j][k] = make([]int, config.NumFloors)
-			initializedLocalQueue[j][k] = make([]int, config.NumFloors)
-		}
-	}
-
-	for i := 0; i < config.MaxNumElevators; i++ {
-		elevData[i] = esm.ElevData{
-			OrderStatus: initializedOrderStatus[i],
-			LocalQueue:  initializedLocalQueue[i],
-			Online:      false,
-		}
+func Synchronize(channels Channels, cfg config.Config, myID string) {
+	table := newPeerTable(myID, cfg)
+	self := table.self()
+
+	// Peers rejected because the table was full, admitted once there is room.
+	rejectedPeers := make(map[string]bool)
+
+	// Cab orders of lost peers, handed back when they reconnect. Recoveries
+	// are only accepted shortly after startup, as a peer that was merely
+	// disconnected may have served its cab orders since they were retained.
+	lostCabOrders := make(map[string][]int)
+	pendingRecoveries := make(map[string]pendingRecovery)
+	acceptRecoveriesUntil := time.Now().Add(cfg.WatchDogTimerDuration)
+	recoveredCabOrders := make([]bool, cfg.NumFloors)
+
+	// Hall orders pressed while being served, registered once cleared, or
+	// before the state of every online peer is known.
+	pendingHallOrders := make([][]bool, config.NumButtonTypes)
+	for i := range pendingHallOrders {
+		pendingHallOrders[i] = make([]bool, cfg.NumFloors)
 	}
-	elevData[0].ID = myID
 
-	sendOutgoinUpdateTimer := time.NewTimer(time.Millisecond * 100)
+	sendOutgoinUpdateTimer := time.NewTimer(cfg.SendSyncMsgTimerDuration)
 	sendCopyToDist := make(chan bool)
 	OrderStatusUpdate := make(chan bool)
 
@@ -65,35 +69,55 @@ func Synchronize(channels Channels, myID string) {
 			fmt.Printf("  Lost:     %q\n", update.Lost)
 
 			if update.New != "" {
-				found := false
-				for i, elev := range elevData {
-					if update.New == elev.ID {
-						found = true
-						elevData[i].Online = true
-					}
+				if _, err := table.join(update.New); err != nil {
+					fmt.Printf("Rejecting peer %s: %v\n", update.New, err)
+					rejectedPeers[update.New] = true
 				}
-				if !found {
-					for i, elev := range elevData {
-						if elev.ID == "" {
-							elevData[i].ID = update.New
-							elevData[i].Online = true
-							break
-						}
+				if cabOrders, found := lostCabOrders[update.New]; found {
+					fmt.Println("Handing back cab orders to ", update.New, ": ", cabOrders)
+					pendingRecoveries[update.New] = pendingRecovery{
+						recovery: CabOrderRecovery{ID: update.New, CabOrders: cabOrders},
+						expires:  time.Now().Add(cfg.WatchDogTimerDuration),
 					}
+					delete(lostCabOrders, update.New)
 				}
 			}
 			for _, lostID := range update.Lost {
-				for i, elev := range elevData {
-					if elev.ID == lostID {
-						elevData[i].Online = false
-					}
+				delete(rejectedPeers, lostID)
+				if elev, found := table.leave(lostID, time.Now()); found &&
+					lostID != myID && hasCabOrders(cabQueue(*elev)) {
+					lostCabOrders[lostID] = append([]int(nil), cabQueue(*elev)...)
 				}
 			}
 			go func() { sendCopyToDist <- true }()
 
 		case <-sendOutgoinUpdateTimer.C:
-			sendOutgoinUpdateTimer.Reset(time.Millisecond * 100)
-			channels.OutgoingMsg <- elevData[0]
+			sendOutgoinUpdateTimer.Reset(cfg.SendSyncMsgTimerDuration)
+			channels.OutgoingMsg <- sentElevData(*self, time.Now())
+			for id, pending := range pendingRecoveries {
+				if time.Now().After(pending.expires) {
+					delete(pendingRecoveries, id)
+					continue
+				}
+				channels.OutgoingCabRecovery <- pending.recovery
+			}
+			if evicted := table.evict(time.Now()); len(evicted) > 0 {
+				fmt.Printf("Evicted peers lost for more than %v: %q\n", cfg.PeerEvictionDuration, evicted)
+				go func() { sendCopyToDist <- true }()
+			}
+
+		case recovery := <-channels.IncomingCabRecovery:
+			if recovery.ID != myID || time.Now().After(acceptRecoveriesUntil) {
+				break
+			}
+			for floor, isOrder := range recovery.CabOrders {
+				if isOrder == 1 && floor < cfg.NumFloors && !recoveredCabOrders[floor] {
+					recoveredCabOrders[floor] = true
+					order := elevio.MakeButtonEvent(elevio.BT_Cab, floor)
+					println("Recovered cab order from peer: Floor:", floor)
+					go func() { channels.RecoveredCabOrder <- order }()
+				}
+			}
 
 		case shallowElevUpdate := <-channels.IncomingMsg:
 			var elevUpdate esm.ElevData
@@ -102,97 +126,101 @@ func Synchronize(channels Channels, myID string) {
 			if elevUpdate.ID == myID {
 				break
 			}
-			for i, elev := range elevData {
-				if elev.ID == elevUpdate.ID &&
-					!hasPeerChange(elev, elevUpdate) {
-					elevData[i].State = elevUpdate.State
-					elevData[i].HeadingDir = elevUpdate.HeadingDir
-					elevData[i].Floor = elevUpdate.Floor
-					elevData[i].OrderStatus = elevUpdate.OrderStatus
-					elevData[i].LocalQueue = elevUpdate.LocalQueue
-					go func() { sendCopyToDist <- true }() // check if we need this
-					go func() { OrderStatusUpdate <- true }()
+			if err := checkShape(elevUpdate, cfg.NumFloors); err != nil {
+				fmt.Printf("Dropping state of %s: %v\n", elevUpdate.ID, err)
+				break
+			}
+			elevUpdate = receivedElevData(elevUpdate, time.Now())
+			if pending, found := pendingRecoveries[elevUpdate.ID]; found &&
+				hasRecovered(elevUpdate, pending.recovery.CabOrders) {
+				delete(pendingRecoveries, elevUpdate.ID)
+			}
+			elev, found := table.get(elevUpdate.ID)
+			if !found && rejectedPeers[elevUpdate.ID] {
+				if joined, err := table.join(elevUpdate.ID); err == nil {
+					fmt.Printf("Admitting peer %s rejected earlier\n", elevUpdate.ID)
+					delete(rejectedPeers, elevUpdate.ID)
+					elev, found = joined, true
 				}
-
+			}
+			if found && table.heard(elevUpdate.ID) {
+				go func() { OrderStatusUpdate <- true }()
+			}
+			if found && !hasPeerChange(*elev, elevUpdate) {
+				elev.State = elevUpdate.State
+				elev.HeadingDir = elevUpdate.HeadingDir
+				elev.Floor = elevUpdate.Floor
+				elev.Obstructed = elevUpdate.Obstructed
+				elev.MotorFault = elevUpdate.MotorFault
+				elev.OrderStatus = elevUpdate.OrderStatus
+				elev.ConfirmedAt = elevUpdate.ConfirmedAt
+				elev.LocalQueue = elevUpdate.LocalQueue
+				go func() { sendCopyToDist <- true }() // check if we need this
+				go func() { OrderStatusUpdate <- true }()
 			}
 
 		case <-sendCopyToDist:
-			copyData := make([]esm.ElevData, config.MaxNumElevators)
+			elevData := table.elevators()
+			copyData := make([]esm.ElevData, len(elevData))
 			esm.DeepCopy(&copyData, &elevData)
 			channels.SyncedElevData <- copyData
 
 		case order := <-channels.CompletedOrder:
 			println("Receiving completed order")
-			fmt.Println(elevData[0].OrderStatus)
-			if elevData[0].OrderStatus[int(order.Button)][order.Floor] == 1 {
-				elevData[0].OrderStatus[int(order.Button)][order.Floor] = -1
-				println("Updated OrderStatus to -1")
-				fmt.Println(elevData[0].OrderStatus)
-				//go func() { sendCopyToDist <- true }()
+			fmt.Println(self.OrderStatus)
+			if HallOrderSet(self.OrderStatus).Remove(order) {
+				println("Updated OrderStatus to served")
+				fmt.Println(self.OrderStatus)
+				sendOrderEvent(channels, cfg, *self, order, time.Now())
 				go func() { OrderStatusUpdate <- true }()
 			}
 
 		case order := <-channels.HallOrder:
-			if elevData[0].OrderStatus[int(order.Button)][order.Floor] == 0 {
-				elevData[0].OrderStatus[int(order.Button)][order.Floor] = 1
+			if pressHallOrder(*self, pendingHallOrders, order, table.isSynced(), time.Now()) {
+				sendOrderEvent(channels, cfg, *self, order, time.Now())
 			}
 			go func() { OrderStatusUpdate <- true }()
 
+		case event := <-channels.IncomingOrderEvent:
+			elev, found := table.get(event.ID)
+			if !found || event.ID == myID {
+				break
+			}
+			if changed, err := applyOrderEvent(elev, event, cfg.NumFloors, time.Now()); err != nil {
+				fmt.Printf("Dropping order event of %s: %v\n", event.ID, err)
+			} else if changed {
+				go func() { OrderStatusUpdate <- true }()
+			}
+
 		case shallowElevUpdate := <-channels.LocalElevData:
 			var elevUpdate esm.ElevData
 			esm.DeepCopy(&elevUpdate, &shallowElevUpdate)
 
-			if !hasLocalUpdate(elevData[0], elevUpdate) {
-				elevData[0].State = elevUpdate.State
-				elevData[0].HeadingDir = elevUpdate.HeadingDir
-				elevData[0].Floor = elevUpdate.Floor
-				elevData[0].LocalQueue = elevUpdate.LocalQueue
+			if !hasLocalUpdate(*self, elevUpdate) {
+				self.State = elevUpdate.State
+				self.HeadingDir = elevUpdate.HeadingDir
+				self.Floor = elevUpdate.Floor
+				self.Obstructed = elevUpdate.Obstructed
+				self.MotorFault = elevUpdate.MotorFault
+				self.LocalQueue = elevUpdate.LocalQueue
 
 				go func() { sendCopyToDist <- true }()
 			}
 
 		case <-OrderStatusUpdate:
 			println("Recieved OrderStatusUpdate")
-			fmt.Println(elevData[0].OrderStatus)
+			fmt.Println(self.OrderStatus)
 
-			originalElevData := make([]esm.ElevData, config.MaxNumElevators)
+			elevData := table.elevators()
+			originalElevData := make([]esm.ElevData, len(elevData))
 			esm.DeepCopy(&originalElevData, &elevData)
 
-			for buttonNr := 0; buttonNr < config.NumButtonTypes; buttonNr++ {
-				for floorNr := 0; floorNr < config.NumFloors; floorNr++ {
-
-					switch originalElevData[0].OrderStatus[buttonNr][floorNr] {
-					case 0:
-						for i := 1; i < config.MaxNumElevators; i++ {
-							if originalElevData[i].OrderStatus[buttonNr][floorNr] == 1 {
-								elevData[0].OrderStatus[buttonNr][floorNr] = 1
-							}
-						}
-
-					case 1:
-						for i := 1; i < config.MaxNumElevators; i++ {
-							if originalElevData[i].OrderStatus[buttonNr][floorNr] == -1 {
-								elevData[0].OrderStatus[buttonNr][floorNr] = -1
-							}
-						}
-
-					case -1:
-						notOne := true
-						for i := 1; i < config.MaxNumElevators; i++ {
-							if originalElevData[i].Online && elevData[i].OrderStatus[buttonNr][floorNr] == 1 {
-								notOne = false
-							}
-						}
-						if isAlone(originalElevData) || notOne {
-							elevData[0].OrderStatus[buttonNr][floorNr] = 0
-							order := elevio.MakeButtonEvent(buttonNr, floorNr)
-							go func() { channels.ClearedOrderStatusOrder <- order }()
-
-						}
-					}
-				}
+			for _, order := range updateHallOrders(elevData, pendingHallOrders, table.isSynced(), time.Now()) {
+				order := order
+				go func() { channels.ClearedOrderStatusOrder <- order }()
 			}
-			if hasPeerChange(originalElevData[0], elevData[0]) {
+			// hasPeerChange is false when the order states have changed.
+			if !hasPeerChange(originalElevData[0], *self) {
 				go func() { sendCopyToDist <- true }()
 			}
 		}